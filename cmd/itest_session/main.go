@@ -0,0 +1,71 @@
+// itest_session looks up the running svcinit session for a target, so that scripts and
+// IDE run configurations can find the services started by `bazel run`.
+//
+// Usage:
+//
+//	itest_session [-env] [-port <name>] <target>
+//	itest_session -list
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"rules_itest/session"
+)
+
+func main() {
+	list := flag.Bool("list", false, "List all registered sessions")
+	env := flag.Bool("env", false, "Print the path of the sourceable env file instead of the manifest")
+	port := flag.String("port", "", "Print the assigned port with the given name instead of the manifest")
+	flag.Parse()
+
+	dir := session.RegistryDir()
+
+	if *list {
+		manifests, err := session.List(dir)
+		must(err)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "Target\tPid\tSvcctl\tReady\tAlive")
+		for _, m := range manifests {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%t\n", m.Target, m.Pid, m.SvcctlAddress, m.Ready, m.Alive())
+		}
+		must(w.Flush())
+		return
+	}
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: itest_session [-env] [-port <name>] <target> | itest_session -list")
+		os.Exit(2)
+	}
+
+	m, err := session.Find(dir, flag.Arg(0))
+	must(err)
+
+	switch {
+	case *env:
+		fmt.Println(m.EnvPath(dir))
+	case *port != "":
+		p, ok := m.Ports[*port]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "no port named %q in session for %s\n", *port, m.Target)
+			os.Exit(1)
+		}
+		fmt.Println(p)
+	default:
+		data, err := json.MarshalIndent(m, "", "  ")
+		must(err)
+		fmt.Println(string(data))
+	}
+}
+
+func must(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"rules_itest/runner"
+	"rules_itest/svclib"
+)
+
+const consoleHelp = `Available commands:
+  status               Show the state of every service and task
+  start <service>      Start a service and wait for it to become healthy
+  stop <service>       Stop a service
+  restart <service>    Stop a service, then start it again
+  logs <service> [n]   Show the last n (default 50) lines of output from a service
+  mute <service>       Hide the output of a service (it is still recorded for logs)
+  unmute <service>     Show the output of a service again
+  ports                Show all assigned ports
+  help                 Show this message
+`
+
+// shouldRunConsole returns true if stdin is attached to a terminal.
+func shouldRunConsole() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+type console struct {
+	ctx           context.Context
+	r             *runner.Runner
+	assignedPorts func() (svclib.Ports, svclib.Hosts)
+	servicesErrCh chan error
+	out           io.Writer
+}
+
+// run reads commands from in until it is closed. Services are controlled through the same
+// runner.Runner and runner.ServiceInstance methods that svcctl uses.
+func (c *console) run(in io.Reader) {
+	fmt.Fprintln(c.out, "Interactive console enabled, type `help` for a list of commands.")
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		err := c.handle(fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(c.out, "%s: %v\n", fields[0], err)
+		}
+	}
+}
+
+func (c *console) handle(command string, args []string) error {
+	switch command {
+	case "help", "?":
+		fmt.Fprint(c.out, consoleHelp)
+		return nil
+	case "status":
+		return c.status()
+	case "ports":
+		return c.printPorts()
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("a service is required, see `help`")
+	}
+	s, err := c.getService(args[0])
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "start":
+		return c.start(s)
+	case "stop":
+		return s.Stop()
+	case "restart":
+		if err := s.Stop(); err != nil {
+			return err
+		}
+		return c.start(s)
+	case "logs":
+		n := 50
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return fmt.Errorf("the number of lines must be positive, got %d", n)
+			}
+		}
+		for _, line := range s.Logs(n) {
+			fmt.Fprintln(c.out, line)
+		}
+		return nil
+	case "mute":
+		s.SetMuted(true)
+		return nil
+	case "unmute":
+		s.SetMuted(false)
+		return nil
+	default:
+		return fmt.Errorf("unknown command, see `help`")
+	}
+}
+
+// getService resolves either a full label or an unambiguous target name, like `db` for `@@//services:db`.
+func (c *console) getService(name string) (*runner.ServiceInstance, error) {
+	s := c.r.GetInstance(name)
+	if s == nil {
+		var matches []string
+		for _, label := range c.r.Labels() {
+			if strings.HasSuffix(label, ":"+name) || strings.TrimPrefix(label, "@@") == name {
+				matches = append(matches, label)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("instance %q not found", name)
+		case 1:
+			s = c.r.GetInstance(matches[0])
+		default:
+			return nil, fmt.Errorf("%q is ambiguous: %s", name, strings.Join(matches, ", "))
+		}
+	}
+
+	if s.Type == "group" {
+		return nil, fmt.Errorf("instance %q is a group", name)
+	}
+	return s, nil
+}
+
+func (c *console) start(s *runner.ServiceInstance) error {
+	err := c.r.StartInstance(c.ctx, s, c.servicesErrCh)
+	if err != nil {
+		return err
+	}
+	return s.WaitUntilHealthy(c.ctx)
+}
+
+func (c *console) status() error {
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Target\tType\tStatus\tPid")
+	for _, label := range c.r.Labels() {
+		s := c.r.GetInstance(label)
+		if s == nil {
+			// Removed by a restart in the meantime.
+			continue
+		}
+		pid := "-"
+		if s.Status() == "running" {
+			pid = strconv.Itoa(s.Pid())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", label, s.Type, s.Status(), pid)
+	}
+	return w.Flush()
+}
+
+func (c *console) printPorts() error {
+	ports, _ := c.assignedPorts()
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		// Skip the legacy colon-separated aliases for named ports.
+		if strings.Count(name, ":") > 1 {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Port name\tPort")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, ports[name])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"time"
+)
+
+// ibazel reports build events over stdin, one per line. For details, see
+// https://github.com/bazelbuild/bazel-watcher#running-a-target-with-ibazel
+const (
+	ibazelBuildStarted          = "IBAZEL_BUILD_STARTED"
+	ibazelBuildCompletedSuccess = "IBAZEL_BUILD_COMPLETED SUCCESS"
+	ibazelBuildCompletedFailure = "IBAZEL_BUILD_COMPLETED FAILURE"
+)
+
+// readNotifications forwards each line of r to notifications.
+func readNotifications(r io.Reader, notifications chan<- string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		notifications <- scanner.Text()
+	}
+}
+
+// debounceNotifications parses the ibazel protocol from notifications and sends the notification that
+// should trigger a restart to reloadCh. Failed builds never trigger a restart, and a burst of
+// successful builds is coalesced into a single restart once no new build has started for `debounce`.
+func debounceNotifications(notifications <-chan string, debounce time.Duration, reloadCh chan<- string) {
+	var pending string
+	var debounceCh <-chan time.Time
+	for {
+		select {
+		case line := <-notifications:
+			switch line {
+			case ibazelBuildStarted:
+				if !terseOutput {
+					log.Println("ibazel: build started")
+				}
+				// Whatever we were about to restart for will be picked up once this build completes.
+				pending = ""
+				debounceCh = nil
+			case ibazelBuildCompletedFailure:
+				log.Println("ibazel: build failed, leaving services running")
+				pending = ""
+				debounceCh = nil
+			case ibazelBuildCompletedSuccess:
+				pending = line
+				debounceCh = time.After(debounce)
+			default:
+				// Not part of the protocol we know about, so preserve the old behavior of restarting.
+				pending = line
+				debounceCh = time.After(debounce)
+			}
+		case <-debounceCh:
+			reloadCh <- pending
+			pending = ""
+			debounceCh = nil
+		}
+	}
+}
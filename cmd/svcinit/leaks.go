@@ -0,0 +1,18 @@
+package main
+
+import "log"
+
+type leakedProcess struct {
+	Pid     int
+	Cmdline string
+}
+
+// checkForLeakedProcesses must be called after all services were stopped. It reports and kills any
+// descendants that escaped their service's process group, and returns true if there were any.
+func checkForLeakedProcesses() bool {
+	leaked := killLeakedProcesses()
+	for _, p := range leaked {
+		log.Printf("Killed leaked process %d, which outlived its service: %s\n", p.Pid, p.Cmdline)
+	}
+	return len(leaked) > 0
+}
@@ -0,0 +1,144 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"rules_itest/runner"
+)
+
+// becomeSubreaper makes orphaned descendants (e.g. daemons that double-fork or call setsid)
+// get reparented to svcinit instead of init, so that we can find them after shutdown.
+func becomeSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+// reapOrphans reaps the descendants that were reparented to svcinit once they exit, so that they do not pile up as
+// zombies over a long session. Children that svcinit started itself are left to their exec.Cmd.
+func reapOrphans() {
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	for range sigchld {
+		runner.WithChildrenLocked(func(isOwned func(pid int) bool) {
+			for _, p := range descendants() {
+				if p.ppid == os.Getpid() && p.zombie && !isOwned(p.pid) {
+					var status syscall.WaitStatus
+					syscall.Wait4(p.pid, &status, syscall.WNOHANG, nil)
+				}
+			}
+		})
+
+		// Every health check command raises SIGCHLD, so only scan once in a while.
+		time.Sleep(time.Second)
+	}
+}
+
+type procStat struct {
+	pid    int
+	ppid   int
+	zombie bool
+}
+
+func readProcStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return procStat{}, false
+	}
+
+	// The command name is in parens and may contain spaces, so skip past it first.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 2 {
+		return procStat{}, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, false
+	}
+	return procStat{pid: pid, ppid: ppid, zombie: fields[0] == "Z"}, true
+}
+
+func descendants() []procStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	children := map[int][]procStat{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, ok := readProcStat(pid)
+		if ok {
+			children[stat.ppid] = append(children[stat.ppid], stat)
+		}
+	}
+
+	var result []procStat
+	queue := []int{os.Getpid()}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			result = append(result, child)
+			queue = append(queue, child.pid)
+		}
+	}
+	return result
+}
+
+func cmdline(pid int) string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cmdline")
+	if err != nil {
+		return "<unknown>"
+	}
+	return strings.Join(strings.Fields(strings.ReplaceAll(string(data), "\x00", " ")), " ")
+}
+
+// killLeakedProcesses kills any descendants that are still running after all services were stopped,
+// and returns the ones it found. Exited descendants that were never waited for are reaped silently.
+func killLeakedProcesses() []leakedProcess {
+	seen := map[int]bool{}
+	var leaked []leakedProcess
+
+	// Killing a process reparents its children to us, so keep going until nothing is left.
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		procs := descendants()
+		if len(procs) == 0 {
+			break
+		}
+
+		for _, p := range procs {
+			if !p.zombie {
+				if !seen[p.pid] {
+					seen[p.pid] = true
+					leaked = append(leaked, leakedProcess{Pid: p.pid, Cmdline: cmdline(p.pid)})
+				}
+				syscall.Kill(p.pid, syscall.SIGKILL)
+			}
+		}
+
+		runner.WithChildrenLocked(func(isOwned func(pid int) bool) {
+			for _, p := range procs {
+				if p.ppid == os.Getpid() && !isOwned(p.pid) {
+					var status syscall.WaitStatus
+					syscall.Wait4(p.pid, &status, syscall.WNOHANG, nil)
+				}
+			}
+		})
+
+		time.Sleep(10 * time.Millisecond)
+	}
+	return leaked
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+// becomeSubreaper is a no-op, child subreapers are only available on Linux.
+func becomeSubreaper() error {
+	return nil
+}
+
+// reapOrphans is a no-op, there are no orphans to reap without a subreaper.
+func reapOrphans() {}
+
+// killLeakedProcesses cannot find descendants that escaped their process group without a subreaper.
+func killLeakedProcesses() []leakedProcess {
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+
+	"rules_itest/svclib"
+)
+
+var perServiceLoopback = os.Getenv("SVCINIT_PER_SERVICE_LOOPBACK") == "True"
+
+// loopbackHosts holds the addresses handed out by assignLoopbackHosts, keyed by label.
+var loopbackHosts svclib.Hosts
+
+// assignLoopbackHosts gives every service and task its own address in a 127.x.y.0/24 block, so that services
+// can bind the same port side by side. The block is picked at random, and reserved if port reservations are
+// enabled, so that concurrent sessions get different blocks. It returns nil if the mode is disabled.
+func assignLoopbackHosts(serviceSpecs map[string]svclib.ServiceSpec) (svclib.Hosts, error) {
+	if !perServiceLoopback {
+		return nil, nil
+	}
+	if runtime.GOOS != "linux" {
+		// Other platforms only route 127.0.0.1 to loopback unless aliases are configured.
+		log.Printf("WARNING: per-service loopback addresses are only supported on linux, using 127.0.0.1\n")
+		perServiceLoopback = false
+		return nil, nil
+	}
+
+	labels := make([]string, 0, len(serviceSpecs))
+	for label, spec := range serviceSpecs {
+		if spec.Type != "group" {
+			labels = append(labels, label)
+		}
+	}
+	slices.Sort(labels)
+	if len(labels) > 254 {
+		return nil, fmt.Errorf("per-service loopback addresses support at most 254 services, got %d", len(labels))
+	}
+
+	block, err := reserveLoopbackBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := svclib.Hosts{}
+	for i, label := range labels {
+		hosts[label] = net.IPv4(127, block[0], block[1], byte(i+1)).String()
+		if !terseOutput {
+			log.Printf("Assigning address %s to %s\n", hosts[label], label)
+		}
+	}
+	loopbackHosts = maps.Clone(hosts)
+	return hosts, nil
+}
+
+// exportedPorts returns the ports as exported in ASSIGNED_PORTS. Per-service addresses are included as
+// "host:<label>", the same name as their substitution, so that GET_ASSIGNED_PORT_BIN can look them up too.
+func exportedPorts(ports svclib.Ports) svclib.Ports {
+	exported := maps.Clone(ports)
+	for label, host := range loopbackHosts {
+		exported["host:"+label] = host
+	}
+	return exported
+}
+
+func reserveLoopbackBlock() ([2]byte, error) {
+	for range 100 {
+		// Stay clear of 127.0.0.0/24, which is where everything else lives.
+		block := [2]byte{byte(1 + rand.IntN(255)), byte(rand.IntN(256))}
+		reserved, err := reservations.reserve("loopback", fmt.Sprintf("%d.%d", block[0], block[1]))
+		if err != nil {
+			return block, fmt.Errorf("reserving loopback block: %w", err)
+		}
+		if reserved {
+			return block, nil
+		}
+	}
+	return [2]byte{}, fmt.Errorf("could not find a loopback block that is not reserved by another svcinit")
+}
+
+// pointAtServiceHost rewrites a URL on 127.0.0.1 or localhost, such as a health check address, to use host instead.
+func pointAtServiceHost(address string, host string) string {
+	u, err := url.Parse(address)
+	if err != nil || (u.Hostname() != "127.0.0.1" && u.Hostname() != "localhost") {
+		return address
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else {
+		u.Host = host
+	}
+	return u.String()
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"maps"
 	"math"
@@ -14,6 +15,9 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,6 +28,8 @@ import (
 
 	"rules_itest/logger"
 	"rules_itest/runner"
+	"rules_itest/runner/topological"
+	"rules_itest/session"
 	"rules_itest/svcctl"
 	"rules_itest/svclib"
 )
@@ -39,16 +45,30 @@ var (
 	allowConfiguringTmpdir = os.Getenv("SVCINIT_ALLOW_CONFIGURING_TMPDIR") == "True"
 	enablePerServiceReload = os.Getenv("SVCINIT_ENABLE_PER_SERVICE_RELOAD") == "True"
 	shouldKeepServicesUp   = os.Getenv("SVCINIT_KEEP_SERVICES_UP") == "True"
+	shouldDumpGraph        = os.Getenv("SVCINIT_DUMP_GRAPH") == "True"
+	shouldWatch            = os.Getenv("SVCINIT_WATCH") == "True" && os.Getenv("BAZEL_TEST") != "1"
+	enableConsole          = os.Getenv("SVCINIT_INTERACTIVE_CONSOLE") == "True"
+	failOnLeakedProcesses  = os.Getenv("SVCINIT_FAIL_ON_LEAKED_PROCESSES") == "True"
 )
 
 // Assigned by x_def
 var getAssignedPortRlocationPath string
 
 func main() {
+	// Must come first, since svcinit may have been started to exec a service with its process attributes applied.
+	runner.MaybeApplyProcessAttributes()
+
 	start := time.Now()
 
 	log.SetFlags(log.Ltime | log.Lmicroseconds)
 
+	err := becomeSubreaper()
+	if err != nil {
+		log.Printf("Failed to become a child subreaper, leaked processes will not be detected: %v\n", err)
+	} else {
+		go reapOrphans()
+	}
+
 	serviceSpecsPath, err := runfiles.Rlocation(os.Getenv("SVCINIT_SERVICE_SPECS_RLOCATION_PATH"))
 	must(err)
 
@@ -63,17 +83,22 @@ func main() {
 	shouldHotReload := os.Getenv("IBAZEL_NOTIFY_CHANGES") == "y"
 	testLabel := os.Getenv("TEST_TARGET")
 
-	interactiveCh := make(chan string, 100)
+	// Must be computed before we override TMPDIR below.
+	sessionDir := session.RegistryDir()
+
+	// Both ibazel and the file watcher speak the ibazel protocol over this channel.
+	notifications := make(chan string, 100)
+	reloadCh := make(chan string, 100)
+	if shouldHotReload || shouldWatch {
+		debounce, err := time.ParseDuration(os.Getenv("SVCINIT_IBAZEL_DEBOUNCE"))
+		if err != nil {
+			log.Printf("failed to parse ibazel debounce duration, falling back to 100ms: %v", err)
+			debounce = 100 * time.Millisecond
+		}
+		go debounceNotifications(notifications, debounce, reloadCh)
+	}
 	if shouldHotReload {
-		go func() {
-			scanner := bufio.NewScanner(os.Stdin)
-			for scanner.Scan() {
-				// TODO: better notification setup needed
-				interactiveCh <- scanner.Text()
-				//close(interactiveCh)
-				//interactiveCh = make(chan struct{})
-			}
-		}()
+		go readNotifications(os.Stdin, notifications)
 	}
 
 	// Sockets have a short max path length (108 chars) so the TEST_TMPDIR path is way too long.
@@ -108,17 +133,29 @@ func main() {
 	must(err)
 	os.Setenv("GET_ASSIGNED_PORT_BIN", getAssignedPortBinPath)
 
-	isOneShot := !shouldHotReload && testLabel != "" && !shouldKeepServicesUp
+	isOneShot := !shouldHotReload && !shouldWatch && testLabel != "" && !shouldKeepServicesUp
 
 	unversionedSpecs, err := readServiceSpecs(serviceSpecsPath)
 	must(err)
 
+	targetLabel := os.Getenv("SVCINIT_TARGET_LABEL")
+	// Tests must not depend on, or clobber, the ports of a previous session.
+	useStablePorts := os.Getenv("SVCINIT_STABLE_PORTS") == "True" && os.Getenv("BAZEL_TEST") != "1" && !isOneShot
+	stablePorts = newStablePortState(useStablePorts, targetLabel)
+	if !isOneShot {
+		reapStaleSessions(sessionDir, targetLabel)
+	}
+
 	// Make sure we grab the svcctl port before we assign test ports,
 	// otherwise we might steal an assigned port by accident.
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	must(err)
 
-	ports, err := assignPorts(unversionedSpecs)
+	ports, hosts, sockets, err := assignPorts(unversionedSpecs)
+	var inUse *portInUseError
+	if errors.As(err, &inUse) {
+		log.Fatal(inUse.explain(sessionDir))
+	}
 	must(err)
 
 	svcctlPort := listener.Addr().(*net.TCPAddr).Port
@@ -131,20 +168,55 @@ func main() {
 		defer os.Remove("/tmp/svcctl_port")
 	}
 
-	serviceSpecs, err := augmentServiceSpecs(unversionedSpecs, ports, svcctlPortStr)
+	serviceSpecs, err := augmentServiceSpecs(unversionedSpecs, ports, hosts, sockets, svcctlPortStr)
 	must(err)
 
+	var sess *sessionState
+	if !isOneShot {
+		sess, err = newSessionState(sessionDir, &session.Manifest{
+			Target:        targetLabel,
+			Pid:           os.Getpid(),
+			StartTime:     start,
+			SvcctlAddress: listener.Addr().String(),
+			Ports:         exportedPorts(ports),
+			Hosts:         hosts,
+			SocketDir:     socketDir,
+			TmpDir:        tmpDir,
+		})
+		must(err)
+		defer sess.remove()
+	}
+
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
 	r, err := runner.New(ctx, serviceSpecs)
 	must(err)
+	go sess.trackProcessGroups(ctx, r)
+
+	stopWatching := func() {}
+	watch := func(serviceSpecs map[string]svclib.VersionedServiceSpec) {
+		paths, err := watchedPaths(serviceSpecsPath, serviceSpecs)
+		must(err)
+		stopWatching, err = watchFiles(paths, func(path string) {
+			log.Printf("Detected change to %s\n", path)
+			notifications <- ibazelBuildCompletedSuccess
+		})
+		must(err)
+	}
+	if shouldWatch {
+		watch(serviceSpecs)
+	}
+	defer func() { stopWatching() }()
 
 	servicesErrCh := make(chan error, len(unversionedSpecs))
+	assignedPorts := func() (svclib.Ports, svclib.Hosts) {
+		return snapshotPorts(ports, hosts)
+	}
 
 	go func() {
 		defer listener.Close()
-		err := svcctl.Serve(ctx, listener, r, ports, servicesErrCh)
+		err := svcctl.Serve(ctx, listener, r, assignedPorts, servicesErrCh)
 		if err != nil {
 			log.Fatalf("svcctl.Serve: %v", err)
 		}
@@ -166,13 +238,32 @@ func main() {
 		}
 	}()
 
-	criticalPath, err := r.StartAll(servicesErrCh)
+	rebindState := portState{ports: ports, hosts: hosts, sockets: sockets, svcctlPort: svcctlPortStr, sessionDir: sessionDir}
+	criticalPath, err := startWithRebinding(r, unversionedSpecs, rebindState, servicesErrCh, sess, func() ([]topological.Task, error) {
+		return r.StartAll(servicesErrCh)
+	})
 	if errors.Is(err, context.Canceled) {
 		_, err := r.StopAll()
 		must(err)
+		checkForLeakedProcesses()
 		return
 	}
 	must(err)
+	sess.setReady(true)
+
+	// Under ibazel, stdin carries build notifications instead.
+	if enableConsole && !isOneShot && !shouldHotReload && shouldRunConsole() {
+		c := &console{
+			ctx:           ctx,
+			r:             r,
+			assignedPorts: assignedPorts,
+			servicesErrCh: servicesErrCh,
+			out:           os.Stdout,
+		}
+		go c.run(os.Stdin)
+	}
+
+	leakedProcesses := false
 
 	// API is                 NewWriter(output io.Writer, minwidth, tabwidth, padding int, padchar byte, flags uint) *Writer
 	reportWriter := tabwriter.NewWriter(os.Stdout, 0, 8, 8, ' ', 0)
@@ -189,6 +280,11 @@ func main() {
 		err = reportWriter.Flush()
 		must(err)
 
+		if shouldDumpGraph {
+			err := dumpGraph(r.Graph(ports))
+			must(err)
+		}
+
 		var testCmd *exec.Cmd
 		testCtx, testCancel := context.WithCancel(ctx)
 		testErrCh := make(chan error, 1)
@@ -196,15 +292,22 @@ func main() {
 			// Bazel's args attribute converts $$ to $, so args arrive with
 			// single-$ placeholders (e.g. ${@@//:svc}) unlike env/spec files
 			// which preserve the literal $$ since they're read from JSON.
-			argReplacements := buildReplacements(ports, "${")
+			testEnvTemplate, err := readTestEnv()
+			must(err)
+
+			argExpander := newExpander("${", ports, hosts, testLabel, testEnvTemplate)
 			testArgs := make([]string, len(os.Args[1:]))
 			for i, arg := range os.Args[1:] {
-				testArgs[i] = replaceAll(arg, argReplacements)
+				testArgs[i], err = argExpander.expandField(fmt.Sprintf("args[%d]", i), arg)
+				must(err)
+				// The tasks have all run by now.
+				testArgs[i], err = svclib.ResolveOutputs(testArgs[i])
+				must(err)
 			}
 			testPath, err := runfiles.Rlocation(os.Getenv("SVCINIT_TEST_RLOCATION_PATH"))
 			must(err)
 
-			testEnv, err := buildTestEnv(ports)
+			testEnv, err := buildTestEnv(testLabel, ports, hosts, testEnvTemplate)
 			must(err)
 
 			fmt.Println("")
@@ -213,7 +316,12 @@ func main() {
 			}
 			testStartTime := time.Now()
 
-			testCmd = exec.CommandContext(testCtx, testPath, testArgs...)
+			testExe, testArgs, wrapped := runner.WrapCommand(targetLabel, testPath, testArgs)
+			if wrapped {
+				log.Printf("Running test under %s\n", strings.Join(append([]string{testExe}, testArgs...), " "))
+			}
+
+			testCmd = exec.CommandContext(testCtx, testExe, testArgs...)
 			testCmd.Env = testEnv
 
 			// Adjust remaining timeout to account for service startup.
@@ -231,12 +339,14 @@ func main() {
 			testCmd.Stdout = os.Stdout
 			testCmd.Stderr = os.Stderr
 
-			if err := testCmd.Start(); err != nil {
+			if err := runner.StartCmd(testCmd); err != nil {
 				panic(err)
 			}
 
 			go func() {
-				testErrCh <- testCmd.Wait()
+				err := testCmd.Wait()
+				runner.ReleaseCmd(testCmd)
+				testErrCh <- err
 
 				testDuration := time.Since(testStartTime)
 				log.Printf("Test duration: %s\n", testDuration)
@@ -263,19 +373,21 @@ func main() {
 			log.Println("Shutting down services.")
 			_, err := r.StopAll()
 			must(err)
+			checkForLeakedProcesses()
 			log.Println("Cleaning up.")
 			return
-		case ibazelCmd := <-interactiveCh:
+		case ibazelCmd := <-reloadCh:
 			log.Println(ibazelCmd)
 
 			// Restart any services as needed.
 			unversionedSpecs, err := readServiceSpecs(serviceSpecsPath)
 			must(err)
 
-			serviceSpecs, err := augmentServiceSpecs(unversionedSpecs, ports, svcctlPortStr)
+			serviceSpecs, err := augmentServiceSpecs(unversionedSpecs, ports, hosts, sockets, svcctlPortStr)
 			must(err)
 
 			testCancel()
+			sess.setReady(false)
 
 			// TODO(zbarsky): what is the right behavior here when services are crashing in ibazel mode?
 
@@ -293,8 +405,17 @@ func main() {
 				}
 			}
 
-			criticalPath, err = r.UpdateSpecsAndRestart(serviceSpecs, servicesErrCh, []byte(ibazelCmd))
+			criticalPath, err = startWithRebinding(r, unversionedSpecs, rebindState, servicesErrCh, sess, func() ([]topological.Task, error) {
+				return r.UpdateSpecsAndRestart(serviceSpecs, servicesErrCh, []byte(ibazelCmd+"\n"))
+			})
 			must(err)
+			sess.setReady(true)
+
+			if shouldWatch {
+				// Services may have been added or removed.
+				stopWatching()
+				watch(serviceSpecs)
+			}
 
 			continue
 
@@ -316,6 +437,7 @@ func main() {
 			buf.WriteString("Target\tUser Time\tSystem Time\n")
 			states, err := r.StopAll()
 			must(err)
+			leakedProcesses = checkForLeakedProcesses()
 			for label, state := range states {
 				buf.WriteString(fmt.Sprintf("%s\t%s\t%s\n",
 					label, state.UserTime(), state.SystemTime()))
@@ -343,6 +465,41 @@ func main() {
 			break
 		}
 	}
+
+	if leakedProcesses && failOnLeakedProcesses {
+		log.Fatal("Services leaked processes, marking test as failed.\n\n")
+	}
+}
+
+// dumpGraph writes the service graph into the undeclared test outputs when running under
+// `bazel test`. Otherwise, it prints the DOT representation; the JSON representation is
+// available from svcctl at `/v0/graph`.
+func dumpGraph(graph runner.Graph) error {
+	outputsDir := os.Getenv("TEST_UNDECLARED_OUTPUTS_DIR")
+	if outputsDir == "" {
+		fmt.Println()
+		return graph.WriteDOT(os.Stdout)
+	}
+
+	var dot, jsonBuf bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil {
+		return err
+	}
+	if err := graph.WriteJSON(&jsonBuf); err != nil {
+		return err
+	}
+
+	dotPath := filepath.Join(outputsDir, "service_graph.dot")
+	if err := os.WriteFile(dotPath, dot.Bytes(), 0644); err != nil {
+		return err
+	}
+	jsonPath := filepath.Join(outputsDir, "service_graph.json")
+	if err := os.WriteFile(jsonPath, jsonBuf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote service graph to %s and %s\n", dotPath, jsonPath)
+	return nil
 }
 
 func readServiceSpecs(
@@ -355,57 +512,146 @@ func readServiceSpecs(
 
 	var serviceSpecs map[string]svclib.ServiceSpec
 	err = json.Unmarshal(data, &serviceSpecs)
-	return serviceSpecs, err
+	if err != nil {
+		return nil, err
+	}
+	return serviceSpecs, nil
 }
 
 func assignPorts(
 	serviceSpecs map[string]svclib.ServiceSpec,
 ) (
-	svclib.Ports, error,
+	svclib.Ports, svclib.Hosts, map[string][]svclib.Socket, error,
 ) {
-	var toClose []net.Listener
+	var toClose []io.Closer
 	ports := svclib.Ports{}
+	sockets := map[string][]svclib.Socket{}
+
+	hosts, err := assignLoopbackHosts(serviceSpecs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if hosts == nil {
+		hosts = svclib.Hosts{}
+	}
 
 	for label, spec := range serviceSpecs {
-		namedPorts := maps.Clone(spec.NamedPorts)
-		if spec.AutoassignPort {
-			namedPorts[""] = spec.Port
-		}
-
-		// Note, this can cause collisions. So be careful!
-		// To avoid port collisions, set the `so_reuseport_aware` option on the service definition
-		// and use the SO_REUSEPORT socket option in your services.
-		for portName, port := range namedPorts {
-			// We do a bit of a dance here to set SO_LINGER to 0. For details, see
-			// https://stackoverflow.com/questions/71975992/what-really-is-the-linger-time-that-can-be-set-with-so-linger-on-sockets
-			lc := net.ListenConfig{
-				Control: func(network, address string, conn syscall.RawConn) error {
-					var setSockoptErr error
-					err := conn.Control(func(fd uintptr) {
-						setSockoptErr = setSockoptsForPortAssignment(fd, &syscall.Linger{
-							Onoff:  1,
-							Linger: 0,
-						})
-					})
-					if err != nil {
-						return err
-					}
-					return setSockoptErr
-				},
-			}
+		listeners, err := assignServicePorts(label, spec, ports, hosts, sockets)
+		toClose = append(toClose, listeners...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
-			listener, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:"+port)
-			if err != nil {
-				return nil, err
-			}
-			_, port, err = net.SplitHostPort(listener.Addr().String())
-			if err != nil {
-				return nil, err
+	err = finishPortAssignment(serviceSpecs, ports, hosts, toClose)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stablePorts.save(ports)
+	return ports, hosts, sockets, nil
+}
+
+// assignServicePorts binds the ports of a single service and records them in ports, hosts and sockets.
+// It returns the listeners that must be closed before the service starts.
+func assignServicePorts(
+	label string,
+	spec svclib.ServiceSpec,
+	ports svclib.Ports,
+	hosts svclib.Hosts,
+	sockets map[string][]svclib.Socket,
+) (
+	[]io.Closer, error,
+) {
+	var toClose []io.Closer
+
+	namedPorts := maps.Clone(spec.NamedPorts)
+	if spec.AutoassignPort {
+		namedPorts[""] = spec.Port
+	}
+
+	passSockets := spec.Lazy || spec.SocketActivation
+	if passSockets && runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("%s: lazy services and socket activation are not supported on windows", label)
+	}
+
+	// Note, this can cause collisions. So be careful!
+	// To avoid port collisions, set the `so_reuseport_aware` option on the service definition
+	// and use the SO_REUSEPORT socket option in your services, or use `socket_activation`.
+	// Iterate in a stable order, so that passed sockets always get the same fds.
+	portNames := make([]string, 0, len(namedPorts))
+	for portName := range namedPorts {
+		portNames = append(portNames, portName)
+	}
+	slices.Sort(portNames)
+
+	for _, portName := range portNames {
+		port := namedPorts[portName]
+		options := spec.PortOptions[portName]
+		// With per-service loopback addresses, hosts already has the service's address.
+		ownAddress := perServiceLoopback && hosts[label] != "" && options.Host == "" && (options.Family == "" || options.Family == "v4")
+		if ownAddress {
+			options.Host = hosts[label]
+		}
+		// Ports in the service's own block cannot collide with other sessions once the block is reserved, so they
+		// need no reservation of their own. Without reservations, another session may have picked the same block.
+		reserve := !ownAddress || reservations == nil
+
+		// We do a bit of a dance here to set SO_LINGER to 0. For details, see
+		// https://stackoverflow.com/questions/71975992/what-really-is-the-linger-time-that-can-be-set-with-so-linger-on-sockets
+		// UDP sockets do not linger, so they only need SO_REUSEPORT.
+		var linger *syscall.Linger
+		if options.Protocol != "udp" {
+			linger = &syscall.Linger{
+				Onoff:  1,
+				Linger: 0,
 			}
+		}
+		lc := net.ListenConfig{
+			Control: func(network, address string, conn syscall.RawConn) error {
+				var setSockoptErr error
+				err := conn.Control(func(fd uintptr) {
+					setSockoptErr = setSockoptsForPortAssignment(fd, linger)
+				})
+				if err != nil {
+					return err
+				}
+				return setSockoptErr
+			},
+		}
+
+		qualifiedPortName := label
+		if portName != "" {
+			qualifiedPortName += "." + portName
+		}
+
+		var listener io.Closer
+		var err error
+		if port == "0" {
+			listener, port, err = listenStable(lc, options, qualifiedPortName, reserve)
+		} else {
+			listener, port, err = listenReserved(lc, options, port, reserve)
+		}
+		var inUse *portInUseError
+		if errors.As(err, &inUse) {
+			inUse.Label = label
+			return toClose, inUse
+		}
+		if err != nil {
+			return toClose, fmt.Errorf("%s: %w", label, err)
+		}
+
+		if !terseOutput {
+			log.Printf("Assigning port %s to %s\n", port, qualifiedPortName)
+		}
+
+		ports.Set(qualifiedPortName, port)
+		hosts[qualifiedPortName] = options.ConnectHost()
 
+		{
+			// TODO(zbarsky): Clean this up after April 2026
 			qualifiedPortName := label
 			if portName != "" {
-				qualifiedPortName += "." + portName
+				qualifiedPortName += ":" + portName
 			}
 
 			if !terseOutput {
@@ -413,31 +659,42 @@ func assignPorts(
 			}
 
 			ports.Set(qualifiedPortName, port)
+			hosts[qualifiedPortName] = options.ConnectHost()
+		}
 
-			{
-				// TODO(zbarsky): Clean this up after April 2026
-				qualifiedPortName := label
-				if portName != "" {
-					qualifiedPortName += ":" + portName
-				}
-
-				if !terseOutput {
-					log.Printf("Assigning port %s to %s\n", port, qualifiedPortName)
-				}
-
-				ports.Set(qualifiedPortName, port)
+		if passSockets {
+			// The service inherits the socket instead of binding the port itself,
+			// so nobody else can grab the port in the meantime.
+			file, err := listener.(interface{ File() (*os.File, error) }).File()
+			if err != nil {
+				return append(toClose, listener), err
 			}
-
-			if !spec.SoReuseportAware {
-				toClose = append(toClose, listener)
+			name := portName
+			if name == "" {
+				name = "default"
 			}
+			sockets[label] = append(sockets[label], svclib.Socket{Name: name, File: file})
+			toClose = append(toClose, listener)
+		} else if !spec.SoReuseportAware {
+			toClose = append(toClose, listener)
 		}
 	}
 
+	return toClose, nil
+}
+
+// finishPortAssignment closes the given listeners, resolves port aliases and exports the result in
+// ASSIGNED_PORTS and ASSIGNED_HOSTS.
+func finishPortAssignment(
+	serviceSpecs map[string]svclib.ServiceSpec,
+	ports svclib.Ports,
+	hosts svclib.Hosts,
+	toClose []io.Closer,
+) error {
 	for _, listener := range toClose {
 		err := listener.Close()
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -449,6 +706,7 @@ func assignPorts(
 			}
 
 			ports.Set(qualifiedPortName, ports[aliasedTo])
+			hosts[qualifiedPortName] = hosts[aliasedTo]
 
 			{
 				// TODO(zbarsky): Clean this up after April 2026
@@ -458,6 +716,7 @@ func assignPorts(
 				}
 
 				ports.Set(qualifiedPortName, ports[aliasedTo])
+				hosts[qualifiedPortName] = hosts[aliasedTo]
 			}
 		}
 	}
@@ -466,29 +725,112 @@ func assignPorts(
 	// Give the kernel a bit of time to figure out what we've done.
 	time.Sleep(10 * time.Millisecond)
 
-	serializedPorts, err := ports.Marshal()
+	serializedPorts, err := exportedPorts(ports).Marshal()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	os.Setenv("ASSIGNED_PORTS", string(serializedPorts))
-	return ports, nil
+
+	serializedHosts, err := json.Marshal(hosts)
+	if err != nil {
+		return err
+	}
+	os.Setenv("ASSIGNED_HOSTS", string(serializedHosts))
+	return nil
+}
+
+// listenReserved binds the port, or an ephemeral one if port is 0, and reserves it host-wide if reserve is set.
+// Ephemeral ports that are reserved by another live svcinit are skipped.
+func listenReserved(lc net.ListenConfig, options svclib.PortOptions, port string, reserve bool) (io.Closer, string, error) {
+	// Keep skipped ports bound until we are done, so that the kernel does not hand them out again.
+	var skipped []io.Closer
+	defer func() {
+		for _, listener := range skipped {
+			listener.Close()
+		}
+	}()
+
+	protocol := cmp.Or(options.Protocol, "tcp")
+	address := net.JoinHostPort(options.BindHost(), port)
+	for {
+		var listener io.Closer
+		var addr net.Addr
+		var err error
+		if protocol == "udp" {
+			var conn net.PacketConn
+			conn, err = lc.ListenPacket(context.Background(), options.Network(), address)
+			if err == nil {
+				listener, addr = conn, conn.LocalAddr()
+			}
+		} else {
+			var l net.Listener
+			l, err = lc.Listen(context.Background(), options.Network(), address)
+			if err == nil {
+				listener, addr = l, l.Addr()
+			}
+		}
+		if isAddrInUse(err) {
+			return nil, "", &portInUseError{Protocol: protocol, Host: options.BindHost(), Port: port, Err: err}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, assignedPort, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			listener.Close()
+			return nil, "", err
+		}
+
+		if !reserve {
+			return listener, assignedPort, nil
+		}
+
+		reserved, err := reservations.reserve(protocol, assignedPort)
+		if err != nil {
+			listener.Close()
+			return nil, "", fmt.Errorf("reserving port %s: %w", assignedPort, err)
+		}
+		if reserved {
+			return listener, assignedPort, nil
+		}
+
+		owner, _ := reservations.owner(protocol, assignedPort)
+		if port != "0" {
+			// There is no other port we could use.
+			log.Printf("WARNING: %s port %s is reserved by svcinit (pid %d), using it anyway\n", protocol, assignedPort, owner)
+			return listener, assignedPort, nil
+		}
+		if len(skipped) >= 100 {
+			listener.Close()
+			return nil, "", fmt.Errorf("could not find a port that is not reserved by another svcinit")
+		}
+		if !terseOutput {
+			log.Printf("Skipping %s port %s, which is reserved by svcinit (pid %d)\n", protocol, assignedPort, owner)
+		}
+		skipped = append(skipped, listener)
+	}
 }
 
 func augmentServiceSpecs(
 	serviceSpecs map[string]svclib.ServiceSpec,
 	ports svclib.Ports,
+	hosts svclib.Hosts,
+	sockets map[string][]svclib.Socket,
 	svcctlPort string,
 ) (
 	map[string]svclib.VersionedServiceSpec, error,
 ) {
-	tmpDir := os.Getenv("TMPDIR")
-	socketDir := os.Getenv("SOCKET_DIR")
-
 	versionedServiceSpecs := make(map[string]svclib.VersionedServiceSpec, len(serviceSpecs))
 	for label, serviceSpec := range serviceSpecs {
 		s := svclib.VersionedServiceSpec{
 			ServiceSpec: serviceSpec,
+			Sockets:     sockets[label],
 		}
+		// The substitutions below must not leak into serviceSpecs, which may be augmented again after ports are reassigned.
+		s.Args = slices.Clone(s.Args)
+		s.HealthCheckArgs = slices.Clone(s.HealthCheckArgs)
+		s.Env = maps.Clone(s.Env)
 
 		if s.Type == "group" {
 			versionedServiceSpecs[label] = s
@@ -520,121 +862,148 @@ func augmentServiceSpecs(
 				return nil, err
 			}
 			s.Version = string(version)
+		} else if shouldWatch {
+			// Without per-service reload, the executable itself is the best signal that the code changed.
+			info, err := os.Stat(exePath)
+			if err != nil {
+				return nil, err
+			}
+			s.Version = info.ModTime().String()
 		}
 
 		s.Color = logger.Colorize(s.Label)
+		s.AssignedPorts = assignedPorts(label, serviceSpec, ports, hosts)
 
-		if s.AutoassignPort {
-			port := ports[s.Label]
-			for i := range s.ServiceSpec.Args {
-				s.Args[i] = strings.ReplaceAll(s.Args[i], "$${PORT}", port)
-			}
-			s.HttpHealthCheckAddress = strings.ReplaceAll(s.HttpHealthCheckAddress, "$${PORT}", port)
-			for i := range s.ServiceSpec.HealthCheckArgs {
-				s.HealthCheckArgs[i] = strings.ReplaceAll(s.HealthCheckArgs[i], "$${PORT}", port)
-			}
-			for k, v := range s.Env {
-				s.Env[k] = strings.ReplaceAll(v, "$${PORT}", port)
-			}
-		}
 		s.Env["SVCCTL_PORT"] = svcctlPort
+		if s.Type == "task" {
+			s.Env["ITEST_OUTPUTS_FILE"] = svclib.OutputsFile(label)
+		}
+
+		err = expandServiceSpec(&s, ports, hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		if perServiceLoopback && hosts[label] != "" {
+			// Nothing listens on 127.0.0.1 in this mode, so the address must mean the service's own host.
+			s.HttpHealthCheckAddress = pointAtServiceHost(s.HttpHealthCheckAddress, hosts[label])
+			s.HotReloadHttpAddress = pointAtServiceHost(s.HotReloadHttpAddress, hosts[label])
+		}
 
 		versionedServiceSpecs[label] = s
 	}
 
-	replacements := make([]Replacement, 0, 2+len(ports))
-	replacements = append(replacements,
-		Replacement{Old: "$${TMPDIR}", New: tmpDir},
-		Replacement{Old: "$${SOCKET_DIR}", New: socketDir},
-	)
+	return versionedServiceSpecs, nil
+}
 
-	for label, port := range ports {
-		replacements = append(replacements, Replacement{
-			Old: "$${" + label + "}",
-			New: port,
-		})
+// assignedPorts lists the ports assigned to a service, sorted by name.
+func assignedPorts(label string, spec svclib.ServiceSpec, ports svclib.Ports, hosts svclib.Hosts) []svclib.AssignedPort {
+	portNames := make([]string, 0, len(spec.NamedPorts)+1)
+	if spec.AutoassignPort {
+		portNames = append(portNames, "")
 	}
-
-	replaceAllPorts := func(s string) string {
-		for _, r := range replacements {
-			s = strings.ReplaceAll(s, r.Old, r.New)
-		}
-		return s
+	for portName := range spec.NamedPorts {
+		portNames = append(portNames, portName)
 	}
+	slices.Sort(portNames)
 
-	for label, spec := range versionedServiceSpecs {
-		spec.HttpHealthCheckAddress = replaceAllPorts(spec.HttpHealthCheckAddress)
-		for i := range spec.Args {
-			spec.Args[i] = replaceAllPorts(spec.Args[i])
+	var assigned []svclib.AssignedPort
+	for _, portName := range portNames {
+		qualifiedPortName := label
+		if portName != "" {
+			qualifiedPortName += "." + portName
 		}
-		for i := range spec.HealthCheckArgs {
-			spec.HealthCheckArgs[i] = replaceAllPorts(spec.HealthCheckArgs[i])
+		host := hosts.Host(qualifiedPortName)
+		// Anything but a wildcard is bound on the host that clients connect to, e.g. the per-service address.
+		bindHost := spec.PortOptions[portName].BindHost()
+		if !net.ParseIP(bindHost).IsUnspecified() {
+			bindHost = host
 		}
-		for k, v := range spec.Env {
-			spec.Env[k] = replaceAllPorts(v)
-		}
-		versionedServiceSpecs[label] = spec
+		assigned = append(assigned, svclib.AssignedPort{
+			Name:     portName,
+			Protocol: cmp.Or(spec.PortOptions[portName].Protocol, "tcp"),
+			Host:     host,
+			BindHost: bindHost,
+			Port:     ports[qualifiedPortName],
+		})
 	}
-
-	return versionedServiceSpecs, nil
+	return assigned
 }
 
-type Replacement struct {
-	Old string
-	New string
-}
+// expandServiceSpec substitutes the placeholders in the fields of a service spec that accept them, in place.
+func expandServiceSpec(s *svclib.VersionedServiceSpec, ports svclib.Ports, hosts svclib.Hosts) error {
+	// Env vars may refer to each other, so they are all resolved against the unexpanded env.
+	e := newExpander("$${", ports, hosts, s.Label, maps.Clone(s.Env))
 
-// buildReplacements creates port/env substitution pairs.
-// prefix is "$${" for values from JSON files (which preserve literal $$),
-// or "${" for values from Bazel args (where $$ is already collapsed to $).
-func buildReplacements(ports svclib.Ports, prefix string) []Replacement {
-	replacements := make([]Replacement, 0, 2+len(ports))
-	replacements = append(replacements,
-		Replacement{Old: prefix + "TMPDIR}", New: os.Getenv("TMPDIR")},
-		Replacement{Old: prefix + "SOCKET_DIR}", New: os.Getenv("SOCKET_DIR")},
-	)
-	for label, port := range ports {
-		replacements = append(replacements, Replacement{
-			Old: prefix + label + "}",
-			New: port,
-		})
+	var err error
+	s.HttpHealthCheckAddress, err = e.expandField("http_health_check_address", s.HttpHealthCheckAddress)
+	if err != nil {
+		return err
 	}
-	return replacements
-}
-
-func replaceAll(s string, replacements []Replacement) string {
-	for _, r := range replacements {
-		s = strings.ReplaceAll(s, r.Old, r.New)
+	s.HotReloadHttpAddress, err = e.expandField("hot_reload_http_address", s.HotReloadHttpAddress)
+	if err != nil {
+		return err
+	}
+	s.WorkingDir, err = e.expandField("working_dir", s.WorkingDir)
+	if err != nil {
+		return err
+	}
+	for i := range s.Args {
+		s.Args[i], err = e.expandField(fmt.Sprintf("args[%d]", i), s.Args[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range s.HealthCheckArgs {
+		s.HealthCheckArgs[i], err = e.expandField(fmt.Sprintf("health_check_args[%d]", i), s.HealthCheckArgs[i])
+		if err != nil {
+			return err
+		}
+	}
+	for k, v := range s.Env {
+		s.Env[k], err = e.expandField(fmt.Sprintf("env[%s]", k), v)
+		if err != nil {
+			return err
+		}
 	}
-	return s
+	return nil
 }
 
-func buildTestEnv(ports svclib.Ports) ([]string, error) {
+func readTestEnv() (map[string]string, error) {
 	testEnvPath, err := runfiles.Rlocation(os.Getenv("SVCINIT_TEST_ENV_RLOCATION_PATH"))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	testEnvData, err := os.ReadFile(testEnvPath)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	env := map[string]string{}
 	err = json.Unmarshal(testEnvData, &env)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return env, nil
+}
 
-	replacements := buildReplacements(ports, "$${")
+func buildTestEnv(testLabel string, ports svclib.Ports, hosts svclib.Hosts, env map[string]string) ([]string, error) {
+	e := newExpander("$${", ports, hosts, testLabel, env)
 
 	// Note, this can technically specify the same var multiple times.
 	// Last one wins - hope that's what you wanted!
 	baseEnv := os.Environ()
 	for k, v := range env {
-		baseEnv = append(baseEnv, k+"="+replaceAll(v, replacements))
+		expanded, err := e.expandField(fmt.Sprintf("env[%s]", k), v)
+		if err == nil {
+			expanded, err = svclib.ResolveOutputs(expanded)
+		}
+		if err != nil {
+			return nil, err
+		}
+		baseEnv = append(baseEnv, k+"="+expanded)
 	}
 
 	return baseEnv, nil
 }
-
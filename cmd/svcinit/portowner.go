@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"rules_itest/session"
+)
+
+// portInUseError is returned by assignPorts when a port cannot be bound because something else holds it.
+type portInUseError struct {
+	Label    string
+	Protocol string
+	Host     string
+	Port     string
+	Err      error
+}
+
+func (e *portInUseError) Error() string {
+	return fmt.Sprintf("%s: %s port %s on %s is already in use: %v", e.Label, e.Protocol, e.Port, e.Host, e.Err)
+}
+
+func (e *portInUseError) Unwrap() error {
+	return e.Err
+}
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+type portOwner struct {
+	Pid     int
+	Pgid    int
+	Cmdline string
+}
+
+// explain describes which process holds the port, and which svcinit session it belongs to, if any.
+func (e *portInUseError) explain(sessionDir string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s could not bind %s port %s on %s, it is already in use.", e.Label, e.Protocol, e.Port, e.Host)
+
+	owners, err := findPortOwners(e.Protocol, e.Port)
+	switch {
+	case err != nil:
+		fmt.Fprintf(&b, "\nThe owning process could not be determined: %v", err)
+	case len(owners) == 0:
+		b.WriteString("\nThe owning process could not be found. It may belong to another user or network namespace.")
+	}
+
+	manifests, _ := session.List(sessionDir)
+	for _, owner := range owners {
+		fmt.Fprintf(&b, "\nIt is held by pid %d: %s", owner.Pid, owner.Cmdline)
+		if m := sessionOwning(manifests, owner.Pid, owner.Pgid); m != nil {
+			if m.Pid == owner.Pid {
+				fmt.Fprintf(&b, "\nThat process is the service manager for %s", m.Target)
+			} else {
+				fmt.Fprintf(&b, "\nThat process was started by the session for %s (svcinit pid %d)", m.Target, m.Pid)
+			}
+			if !m.Alive() {
+				b.WriteString(", which is no longer running. Kill the process to free the port.")
+			} else {
+				b.WriteString(", which is still running. Stop it to free the port.")
+			}
+		}
+	}
+
+	if pid, err := reservations.owner(e.Protocol, e.Port); err == nil && len(owners) == 0 {
+		if m := sessionOwning(manifests, pid, 0); m != nil {
+			fmt.Fprintf(&b, "\nThe port was last reserved by the session for %s (svcinit pid %d).", m.Target, m.Pid)
+		} else {
+			fmt.Fprintf(&b, "\nThe port was last reserved by svcinit pid %d.", pid)
+		}
+	}
+	return b.String()
+}
+
+// sessionOwning returns the session whose svcinit is pid, or which recorded the process group pgid.
+func sessionOwning(manifests []*session.Manifest, pid int, pgid int) *session.Manifest {
+	for _, m := range manifests {
+		if m.Pid == pid {
+			return m
+		}
+		for _, g := range m.ProcessGroups {
+			if pgid != 0 && g.Pgid == pgid {
+				return m
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// findPortOwners returns the processes that have a socket bound to the port, on any address.
+func findPortOwners(protocol, port string) ([]portOwner, error) {
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := map[string]bool{}
+	for _, table := range []string{protocol, protocol + "6"} {
+		err := findSocketInodes("/proc/net/"+table, uint16(portNum), inodes)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []portOwner
+	seen := map[int]bool{}
+	for _, fd := range fds {
+		target, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(target, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] {
+			continue
+		}
+
+		pid, err := strconv.Atoi(strings.Split(fd, "/")[2])
+		// svcinit itself keeps the ports of SO_REUSEPORT-aware services bound.
+		if err != nil || seen[pid] || pid == os.Getpid() {
+			continue
+		}
+		seen[pid] = true
+
+		pgid, _ := syscall.Getpgid(pid)
+		owners = append(owners, portOwner{Pid: pid, Pgid: pgid, Cmdline: cmdline(pid)})
+	}
+	return owners, nil
+}
+
+// findSocketInodes adds the inodes of the sockets in a /proc/net table whose local port is port.
+// Each line looks like `sl local_address rem_address st ... inode`, with addresses as hex `ADDR:PORT`.
+func findSocketInodes(path string, port uint16, inodes map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		_, localPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		p, err := strconv.ParseUint(localPort, 16, 16)
+		// Sockets in TIME_WAIT have no inode, and no owner.
+		if err != nil || uint16(p) != port || fields[9] == "0" {
+			continue
+		}
+		inodes[fields[9]] = true
+	}
+	return scanner.Err()
+}
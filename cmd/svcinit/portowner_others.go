@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func findPortOwners(protocol, port string) ([]portOwner, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"rules_itest/runner"
+	"rules_itest/runner/topological"
+	"rules_itest/session"
+	"rules_itest/svclib"
+)
+
+var bindFailureRetries, _ = strconv.Atoi(os.Getenv("SVCINIT_BIND_FAILURE_RETRIES"))
+
+// assignedPortsMu guards the ports and hosts maps while ports are reassigned, since svcctl and the console
+// read them from other goroutines.
+var assignedPortsMu sync.RWMutex
+
+// snapshotPorts returns copies of ports and hosts that are safe to read while ports are reassigned.
+func snapshotPorts(ports svclib.Ports, hosts svclib.Hosts) (svclib.Ports, svclib.Hosts) {
+	assignedPortsMu.RLock()
+	defer assignedPortsMu.RUnlock()
+	return maps.Clone(ports), maps.Clone(hosts)
+}
+
+// portState is everything needed to recompute the service specs after ports are reassigned.
+type portState struct {
+	ports      svclib.Ports
+	hosts      svclib.Hosts
+	sockets    map[string][]svclib.Socket
+	svcctlPort string
+	// Used to explain bind failures that we give up on.
+	sessionDir string
+}
+
+// startWithRebinding calls start, and whenever a service fails because it could not bind its port,
+// assigns the service new ports and starts again. Each service is retried at most bindFailureRetries times.
+func startWithRebinding(
+	r *runner.Runner,
+	unversionedSpecs map[string]svclib.ServiceSpec,
+	state portState,
+	servicesErrCh chan error,
+	sess *sessionState,
+	start func() ([]topological.Task, error),
+) (
+	[]topological.Task, error,
+) {
+	retries := map[string]int{}
+	for {
+		criticalPath, err := start()
+
+		var bindErr *runner.BindError
+		if !errors.As(err, &bindErr) {
+			return criticalPath, err
+		}
+		if retries[bindErr.Label] >= bindFailureRetries {
+			explainBindFailure(bindErr.Label, unversionedSpecs[bindErr.Label], state)
+			return criticalPath, err
+		}
+		retries[bindErr.Label]++
+
+		log.Printf("%s could not bind its port, assigning new ports and retrying (%d/%d)\n",
+			bindErr.Label, retries[bindErr.Label], bindFailureRetries)
+
+		ports, hosts := maps.Clone(state.ports), maps.Clone(state.hosts)
+		err = reassignPorts(bindErr.Label, unversionedSpecs, ports, hosts, state.sockets)
+		if err != nil {
+			return criticalPath, fmt.Errorf("%w\nfailed to reassign ports: %v", bindErr, err)
+		}
+		assignedPortsMu.Lock()
+		maps.Copy(state.ports, ports)
+		maps.Copy(state.hosts, hosts)
+		assignedPortsMu.Unlock()
+		sess.update(func(m *session.Manifest) {
+			m.Ports = exportedPorts(state.ports)
+			m.Hosts = state.hosts
+		})
+
+		// Dependents and anything else that interpolates the old ports gets restarted by UpdateSpecs.
+		serviceSpecs, err := augmentServiceSpecs(unversionedSpecs, state.ports, state.hosts, state.sockets, state.svcctlPort)
+		if err != nil {
+			return criticalPath, err
+		}
+
+		// The service may still be running if it never became healthy, and its spec is unchanged
+		// if it only reads its port from ASSIGNED_PORTS, so make sure it gets started again.
+		// Its exit must not be reported as a crash, even if it already exited on its own.
+		err = r.GetInstance(bindErr.Label).StopQuietly()
+		if err != nil {
+			return criticalPath, err
+		}
+
+		// The failed service may have reported its exit before it was stopped.
+	Drain:
+		for {
+			select {
+			case <-servicesErrCh:
+				// nothing
+			default:
+				break Drain
+			}
+		}
+
+		start = func() ([]topological.Task, error) {
+			return r.UpdateSpecsAndRestart(serviceSpecs, servicesErrCh, nil)
+		}
+	}
+}
+
+// reassignPorts picks new ports for the ephemeral ports of the service, in place.
+// Fixed ports are kept, since there is no other port the service could use.
+func reassignPorts(
+	label string,
+	serviceSpecs map[string]svclib.ServiceSpec,
+	ports svclib.Ports,
+	hosts svclib.Hosts,
+	sockets map[string][]svclib.Socket,
+) error {
+	spec := serviceSpecs[label]
+	if spec.Lazy || spec.SocketActivation {
+		// svcinit binds these ports itself, so the service cannot collide on them.
+		return fmt.Errorf("%s receives its sockets from the service manager, so its ports cannot be reassigned", label)
+	}
+
+	ephemeral := spec
+	ephemeral.AutoassignPort = spec.AutoassignPort && spec.Port == "0"
+	ephemeral.NamedPorts = map[string]string{}
+	for portName, port := range spec.NamedPorts {
+		if port == "0" {
+			ephemeral.NamedPorts[portName] = port
+		}
+	}
+	oldPorts := servicePorts(label, ephemeral, ports)
+	if len(oldPorts) == 0 {
+		return fmt.Errorf("%s only uses fixed ports", label)
+	}
+
+	// The ports from the previous session are the ones that just failed.
+	if ephemeral.AutoassignPort {
+		stablePorts.forget(label)
+	}
+	for portName := range ephemeral.NamedPorts {
+		stablePorts.forget(label + "." + portName)
+	}
+
+	listeners, err := assignServicePorts(label, ephemeral, ports, hosts, sockets)
+	if err != nil {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return err
+	}
+
+	newPorts := servicePorts(label, ephemeral, ports)
+	for _, port := range oldPorts {
+		// The kernel may hand out a port again once its previous user is gone.
+		if !slices.Contains(newPorts, port) {
+			reservations.release(port.protocol, port.port)
+		}
+	}
+
+	err = finishPortAssignment(serviceSpecs, ports, hosts, listeners)
+	if err != nil {
+		return err
+	}
+	stablePorts.save(ports)
+	return nil
+}
+
+type reservedPort struct {
+	protocol string
+	port     string
+}
+
+// servicePorts returns the currently assigned values of the ports declared by spec.
+func servicePorts(label string, spec svclib.ServiceSpec, ports svclib.Ports) []reservedPort {
+	var assigned []reservedPort
+	if spec.AutoassignPort {
+		assigned = append(assigned, reservedPort{"tcp", ports[label]})
+	}
+	for portName := range spec.NamedPorts {
+		protocol := cmp.Or(spec.PortOptions[portName].Protocol, "tcp")
+		assigned = append(assigned, reservedPort{protocol, ports[label+"."+portName]})
+	}
+	return assigned
+}
+
+// explainBindFailure logs which processes hold the ports of a service that could not bind them.
+func explainBindFailure(label string, spec svclib.ServiceSpec, state portState) {
+	portNames := maps.Clone(spec.NamedPorts)
+	if spec.AutoassignPort {
+		portNames[""] = spec.Port
+	}
+
+	for portName := range portNames {
+		qualifiedPortName := label
+		if portName != "" {
+			qualifiedPortName += "." + portName
+		}
+		protocol := cmp.Or(spec.PortOptions[portName].Protocol, "tcp")
+		port := state.ports[qualifiedPortName]
+
+		owners, _ := findPortOwners(protocol, port)
+		if len(owners) == 0 {
+			continue
+		}
+		inUse := &portInUseError{Label: label, Protocol: protocol, Host: state.hosts.Host(qualifiedPortName), Port: port}
+		log.Println(inUse.explain(state.sessionDir))
+	}
+}
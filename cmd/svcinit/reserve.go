@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// portReservations coordinates port assignment between concurrent svcinit processes on the same host,
+// for example parallel `bazel test` runs. Each assigned port is reserved by holding an exclusive lock on
+// `<dir>/<network>-<port>.lock`, which contains the pid of the owner. Locks are released by the kernel when
+// the owner exits, however it exits, so a reservation is held exactly as long as its session is alive.
+//
+// Lock files are never deleted, since deleting a lock file while someone else has it open is racy.
+type portReservations struct {
+	dir string
+
+	mu   sync.Mutex
+	held map[string]*os.File
+}
+
+var reservations = newPortReservations(os.Getenv("SVCINIT_PORT_RESERVATION_DIR"))
+
+// newPortReservations returns nil if dir is empty, in which case reservations are disabled.
+func newPortReservations(dir string) *portReservations {
+	if dir == "" {
+		return nil
+	}
+	return &portReservations{dir: dir, held: map[string]*os.File{}}
+}
+
+func (p *portReservations) path(network, port string) string {
+	return filepath.Join(p.dir, network+"-"+port+".lock")
+}
+
+// reserve takes the reservation for the port. It returns false if another live svcinit holds it.
+func (p *portReservations) reserve(network, port string) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+
+	err := os.MkdirAll(p.dir, 0777)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(p.path(network, port), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return false, err
+	}
+
+	locked, err := tryLockFile(f)
+	if err != nil || !locked {
+		f.Close()
+		return false, err
+	}
+
+	// Purely informational, the lock is what counts.
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Keep the file open (and referenced, so it is not closed by the GC) until we exit.
+	p.held[network+"-"+port] = f
+	return true, nil
+}
+
+// release gives up our reservation for the port, e.g. when it is reassigned.
+func (p *portReservations) release(network, port string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := network + "-" + port
+	if f, ok := p.held[key]; ok {
+		f.Close()
+		delete(p.held, key)
+	}
+}
+
+// owner returns the pid of the svcinit that last reserved the port, if any.
+func (p *portReservations) owner(network, port string) (int, error) {
+	if p == nil {
+		return 0, fmt.Errorf("port reservations are disabled")
+	}
+
+	data, err := os.ReadFile(p.path(network, port))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"rules_itest/runner"
+	"rules_itest/session"
+)
+
+// sessionState keeps the session manifest up to date. All methods are no-ops on a nil receiver,
+// which is used for one-shot test runs that do not register a session.
+type sessionState struct {
+	mu       sync.Mutex
+	dir      string
+	manifest *session.Manifest
+	removed  bool
+}
+
+func newSessionState(dir string, manifest *session.Manifest) (*sessionState, error) {
+	err := manifest.Write(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionState{dir: dir, manifest: manifest}, nil
+}
+
+func (s *sessionState) update(f func(m *session.Manifest)) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removed {
+		return
+	}
+
+	f(s.manifest)
+	err := s.manifest.Write(s.dir)
+	if err != nil {
+		log.Printf("Failed to update session manifest: %v\n", err)
+	}
+}
+
+func (s *sessionState) setReady(ready bool) {
+	s.update(func(m *session.Manifest) {
+		m.Ready = ready
+	})
+}
+
+// trackProcessGroups periodically records the process groups of running services, so that they
+// can be reaped by the next svcinit for this target if we are killed without cleaning up.
+// Services can be started and stopped at any time through svcctl, so polling is simplest.
+func (s *sessionState) trackProcessGroups(ctx context.Context, r *runner.Runner) {
+	if s == nil {
+		return
+	}
+
+	var pgids []int
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if current := r.ProcessGroups(); !slices.Equal(current, pgids) {
+			pgids = current
+			s.update(func(m *session.Manifest) {
+				m.ProcessGroups = session.NewProcessGroups(pgids)
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *sessionState) remove() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = true
+	s.manifest.Remove(s.dir)
+}
+
+// reapStaleSessions kills services left behind by a previous svcinit for the same target that
+// crashed or was SIGKILLed, so that they do not hold on to ports we are about to assign.
+func reapStaleSessions(dir string, target string) {
+	reaped, err := session.ReapStale(dir, target)
+	for _, pgid := range reaped {
+		log.Printf("Killed process group %d left behind by a previous session of %s\n", pgid, target)
+	}
+	if err != nil {
+		log.Printf("Failed to clean up stale sessions: %v\n", err)
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// setSockoptsForPortAssignment sets SO_LINGER, unless l is nil, and SO_REUSEPORT.
 func setSockoptsForPortAssignment(fd uintptr, l *syscall.Linger) error {
-	err := syscall.SetsockoptLinger(int(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, l)
-	if err != nil {
-		return err
+	if l != nil {
+		err := syscall.SetsockoptLinger(int(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, l)
+		if err != nil {
+			return err
+		}
 	}
 
 	// It's unfortunate that we need `unix` here; SO_REUSEPORT is defined on linuxarm64 but not linux...
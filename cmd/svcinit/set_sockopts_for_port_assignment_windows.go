@@ -4,7 +4,11 @@ package main
 
 import "syscall"
 
+// setSockoptsForPortAssignment sets SO_LINGER, unless l is nil.
 func setSockoptsForPortAssignment(fd uintptr, l *syscall.Linger) error {
 	// Windows (even WSL) does not seem to support SO_REUSEPORT
+	if l == nil {
+		return nil
+	}
 	return syscall.SetsockoptLinger(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_LINGER, l)
 }
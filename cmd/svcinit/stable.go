@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"rules_itest/svclib"
+)
+
+// stablePortState remembers the ports assigned in the previous session for a target, so that `bazel run`
+// hands out the same ports every time, as long as they are free. This keeps bookmarks and IDE configs working.
+type stablePortState struct {
+	path string
+
+	mu       sync.Mutex
+	previous svclib.Ports
+}
+
+// Set up by main, since stable ports are only kept for sessions that stay up for a developer to use.
+var stablePorts *stablePortState
+
+// newStablePortState returns nil if stable ports are disabled or there is nowhere to keep them.
+func newStablePortState(enabled bool, target string) *stablePortState {
+	if !enabled || target == "" {
+		return nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.Printf("WARNING: stable ports are disabled, there is no cache directory: %v\n", err)
+		return nil
+	}
+	name := strings.NewReplacer("@", "", "/", "_", ":", "_").Replace(target) + ".json"
+	s := &stablePortState{
+		path:     filepath.Join(cacheDir, "rules_itest", "ports", name),
+		previous: svclib.Ports{},
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s
+	}
+	if err == nil {
+		err = s.previous.Unmarshal(data)
+	}
+	if err != nil {
+		// Start over rather than failing the session over a cache file.
+		log.Printf("WARNING: ignoring stable ports from %s: %v\n", s.path, err)
+		s.previous = svclib.Ports{}
+	}
+	return s
+}
+
+func (s *stablePortState) preferred(name string) string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.previous[name]
+}
+
+// forget drops the remembered port, e.g. because it turned out to be taken.
+func (s *stablePortState) forget(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.previous, name)
+}
+
+// save remembers the ports for the next session.
+func (s *stablePortState) save(ports svclib.Ports) {
+	if s == nil {
+		return
+	}
+
+	data, err := ports.Marshal()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	}
+	if err == nil {
+		tmp := s.path + ".tmp"
+		err = os.WriteFile(tmp, data, 0600)
+		if err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		log.Printf("WARNING: failed to save stable ports to %s: %v\n", s.path, err)
+	}
+}
+
+// listenStable binds the port that name had in the previous session if it is free, or else an ephemeral port.
+func listenStable(lc net.ListenConfig, options svclib.PortOptions, name string, reserve bool) (io.Closer, string, error) {
+	if preferred := stablePorts.preferred(name); preferred != "" {
+		protocol := cmp.Or(options.Protocol, "tcp")
+
+		reserved := true
+		if reserve {
+			var err error
+			reserved, err = reservations.reserve(protocol, preferred)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		if reserved {
+			// It is already reserved, if needed.
+			listener, port, err := listenReserved(lc, options, preferred, false)
+			if err == nil {
+				return listener, port, nil
+			}
+			if reserve {
+				reservations.release(protocol, preferred)
+			}
+		}
+		if !terseOutput {
+			log.Printf("Port %s, which %s had in the previous session, is taken\n", preferred, name)
+		}
+	}
+
+	return listenReserved(lc, options, "0", reserve)
+}
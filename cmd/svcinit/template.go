@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"rules_itest/svclib"
+)
+
+// expander substitutes placeholders in service and test definitions in a single pass, so that substituted
+// values are never themselves scanned for placeholders. The supported forms are:
+//
+//	PORT, HOST            the service's own autoassigned port and host
+//	TMPDIR, SOCKET_DIR    the directories set up by svcinit
+//	<label>               the port assigned to a label, which may be a named port
+//	host:<label>          the host that clients should use to reach the label
+//	address:<label>       host:port of the label
+//	url:<label>           http://host:port of the label
+//	env:<name>            the service's own env var, falling back to the env of svcinit
+//	file:<path>           the contents of a file, as an rlocation or absolute path, without trailing newlines
+//	output:<label>:<key>  an output of a task, which is resolved right before the service or test starts
+//
+// Any form can be followed by `:-<default>`, which is used when the placeholder cannot be resolved, and may itself
+// contain placeholders. The prefix preceded by another `$` is a literal prefix. With the "${" prefix, unknown
+// placeholders that name a shell variable, such as ${HOME} or ${FOO:-bar}, are kept as is, since they may be meant
+// for a shell. Anything else, such as a mistyped label, is still an error.
+type expander struct {
+	// prefix is "$${" for values from JSON files (which preserve literal $$),
+	// or "${" for values from Bazel args (where $$ is already collapsed to $).
+	prefix string
+
+	ports svclib.Ports
+	hosts svclib.Hosts
+	// label is the service or test whose values are expanded.
+	label string
+	// env holds the unexpanded env of the service or test.
+	env map[string]string
+
+	// Env vars that are currently being expanded, to detect cycles.
+	expandingEnv map[string]bool
+}
+
+func newExpander(prefix string, ports svclib.Ports, hosts svclib.Hosts, label string, env map[string]string) *expander {
+	return &expander{
+		prefix:       prefix,
+		ports:        ports,
+		hosts:        hosts,
+		label:        label,
+		env:          env,
+		expandingEnv: map[string]bool{},
+	}
+}
+
+// expandField expands s, naming the owner and field in any error, e.g. "@@//:db: args[2]: ...".
+func (e *expander) expandField(field string, s string) (string, error) {
+	expanded, err := e.expand(s)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s: %w", e.label, field, err)
+	}
+	return expanded, nil
+}
+
+func (e *expander) expand(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, e.prefix)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			// Escaped, keep the prefix and move on.
+			b.WriteString(s[:i-1])
+			b.WriteString(e.prefix)
+			s = s[i+len(e.prefix):]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		start := i + len(e.prefix)
+		end := matchingBrace(s, start)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder %s", s[i:])
+		}
+
+		value, err := e.resolvePlaceholder(s[start:end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		s = s[end+1:]
+	}
+}
+
+// matchingBrace returns the index of the brace that closes the placeholder whose body starts at start, or -1.
+// Nested braces, such as a placeholder in a default, are skipped.
+func matchingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func (e *expander) resolvePlaceholder(body string) (string, error) {
+	key, defaultValue, hasDefault := strings.Cut(body, ":-")
+	if ref, ok := strings.CutPrefix(key, "output:"); ok {
+		return e.resolveOutput(key, ref, defaultValue, hasDefault)
+	}
+
+	value, err := e.resolve(key)
+	if err == nil {
+		return value, nil
+	}
+	if errors.Is(err, errUnknownPlaceholder) && e.prefix == "${" && isShellVariable(key) {
+		return e.prefix + body + "}", nil
+	}
+	if hasDefault {
+		return e.expand(defaultValue)
+	}
+	if errors.Is(err, errUnknownPlaceholder) {
+		return "", fmt.Errorf("unknown placeholder %s%s}", e.prefix, key)
+	}
+	return "", fmt.Errorf("%s%s}: %w", e.prefix, key, err)
+}
+
+var errUnknownPlaceholder = errors.New("unknown placeholder")
+
+// isShellVariable returns true if name is a valid shell variable name, which can never be a label or one of our forms.
+func isShellVariable(name string) bool {
+	if name == "" || ('0' <= name[0] && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *expander) resolve(key string) (string, error) {
+	switch key {
+	case "PORT":
+		port, ok := e.ports[e.label]
+		if !ok {
+			return "", fmt.Errorf("%s has no autoassigned port", e.label)
+		}
+		return port, nil
+	case "HOST":
+		return e.hosts.Host(e.label), nil
+	case "TMPDIR", "SOCKET_DIR":
+		return os.Getenv(key), nil
+	}
+
+	kind, name, _ := strings.Cut(key, ":")
+	switch kind {
+	case "host":
+		_, hasPort := e.ports[name]
+		_, hasHost := e.hosts[name]
+		if !hasPort && !hasHost {
+			return "", fmt.Errorf("no port or host is assigned to %s", name)
+		}
+		return e.hosts.Host(name), nil
+	case "address", "url":
+		address, ok := e.hosts.Address(e.ports, name)
+		if !ok {
+			return "", fmt.Errorf("no port is assigned to %s", name)
+		}
+		if kind == "url" {
+			return "http://" + address, nil
+		}
+		return address, nil
+	case "env":
+		return e.resolveEnv(name)
+	case "file":
+		path := name
+		if !filepath.IsAbs(path) {
+			var err error
+			path, err = runfiles.Rlocation(name)
+			if err != nil {
+				return "", err
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if port, ok := e.ports[key]; ok {
+		return port, nil
+	}
+	return "", errUnknownPlaceholder
+}
+
+// resolveOutput returns a reference to the output of a task, since outputs are only known once the task has run.
+func (e *expander) resolveOutput(key string, ref string, defaultValue string, hasDefault bool) (string, error) {
+	// Labels contain a colon themselves, so the key is whatever follows the last one.
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || !strings.Contains(ref[:i], ":") || i == len(ref)-1 {
+		return "", fmt.Errorf("%s%s}: expected output:<label>:<key>", e.prefix, key)
+	}
+
+	var expandedDefault *string
+	if hasDefault {
+		expanded, err := e.expand(defaultValue)
+		if err != nil {
+			return "", err
+		}
+		expandedDefault = &expanded
+	}
+	return svclib.OutputRef(ref[:i], ref[i+1:], expandedDefault), nil
+}
+
+func (e *expander) resolveEnv(name string) (string, error) {
+	value, ok := e.env[name]
+	if !ok {
+		value, ok = os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("%s is not set", name)
+		}
+		// The env of svcinit is not a template.
+		return value, nil
+	}
+
+	if e.expandingEnv[name] {
+		return "", fmt.Errorf("%s refers to itself", name)
+	}
+	e.expandingEnv[name] = true
+	defer delete(e.expandingEnv, name)
+	return e.expand(value)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"rules_itest/svclib"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("SVCINIT_TEMPLATE_TEST", "from svcinit")
+
+	ports := svclib.Ports{
+		"@@//:svc":      "1234",
+		"@@//:svc.http": "2345",
+		"@@//:svc:http": "2345",
+		"@@//:v6":       "3456",
+	}
+	hosts := svclib.Hosts{
+		"@@//:svc":      "127.0.0.2",
+		"@@//:svc.http": "127.0.0.2",
+		"@@//:svc:http": "127.0.0.2",
+		"@@//:v6":       "::1",
+	}
+	env := map[string]string{
+		"A":      "a",
+		"B":      "$${env:A}-b",
+		"CYCLE1": "$${env:CYCLE2}",
+		"CYCLE2": "$${env:CYCLE1}",
+		"SELF":   "$${env:SELF}",
+	}
+
+	tests := []struct {
+		name    string
+		prefix  string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "no placeholders", input: "plain", want: "plain"},
+		{name: "own port", input: "--port=$${PORT}", want: "--port=1234"},
+		{name: "own host", input: "$${HOST}:$${PORT}", want: "127.0.0.2:1234"},
+		{name: "label", input: "$${@@//:svc}", want: "1234"},
+		{name: "named port", input: "$${@@//:svc.http}", want: "2345"},
+		{name: "colon-separated named port", input: "$${@@//:svc:http}", want: "2345"},
+		{name: "host of colon-separated named port", input: "$${host:@@//:svc:http}", want: "127.0.0.2"},
+		{name: "address", input: "$${address:@@//:svc.http}", want: "127.0.0.2:2345"},
+		{name: "ipv6 address", input: "$${address:@@//:v6}", want: "[::1]:3456"},
+		{name: "url", input: "$${url:@@//:svc}/health", want: "http://127.0.0.2:1234/health"},
+		{name: "escape", input: "$$${PORT}", want: "$${PORT}"},
+		{name: "escape next to placeholder", input: "$$${PORT}$${PORT}", want: "$${PORT}1234"},
+		{name: "own env", input: "$${env:A}", want: "a"},
+		{name: "env referring to env", input: "$${env:B}", want: "a-b"},
+		{name: "svcinit env", input: "$${env:SVCINIT_TEMPLATE_TEST}", want: "from svcinit"},
+		{name: "default", input: "$${env:MISSING:-info}", want: "info"},
+		{name: "default for label with colons", input: "$${@@//:missing:http:-8080}", want: "8080"},
+		{name: "unused default", input: "$${env:A:-unused}", want: "a"},
+		{name: "placeholder in default", input: "$${env:MISSING:-$${PORT}}", want: "1234"},
+		{name: "nested defaults", input: "$${env:MISSING:-$${env:ALSO_MISSING:-x}}", want: "x"},
+		{name: "empty default", input: "[$${env:MISSING:-}]", want: "[]"},
+		{name: "output", input: "$${output:@@//:seed:key}", want: svclib.OutputRef("@@//:seed", "key", nil)},
+		{name: "env cycle", input: "$${env:CYCLE1}", wantErr: "CYCLE1 refers to itself"},
+		{name: "env refers to itself", input: "$${env:SELF}", wantErr: "SELF refers to itself"},
+		{name: "missing env", input: "$${env:MISSING}", wantErr: "MISSING is not set"},
+		{name: "unknown key", input: "$${nope}", wantErr: "unknown placeholder $${nope}"},
+		{name: "unknown label", input: "$${@@//:missing}", wantErr: "unknown placeholder $${@@//:missing}"},
+		{name: "unknown host", input: "$${host:@@//:missing}", wantErr: "no port or host is assigned to @@//:missing"},
+		{name: "unterminated", input: "$${PORT", wantErr: "unterminated placeholder"},
+		{name: "output without key", input: "$${output:@@//:seed}", wantErr: "expected output:<label>:<key>"},
+
+		{name: "bazel arg", prefix: "${", input: "${@@//:svc.http}", want: "2345"},
+		{name: "bazel arg escape", prefix: "${", input: "$${PORT}", want: "${PORT}"},
+		{name: "bazel arg unknown key", prefix: "${", input: "${HOME}/bin", want: "${HOME}/bin"},
+		{name: "bazel arg shell default", prefix: "${", input: "${FOO:-bar}", want: "${FOO:-bar}"},
+		{name: "bazel arg default", prefix: "${", input: "${env:MISSING:-info}", want: "info"},
+		{name: "bazel arg mistyped label", prefix: "${", input: "${//svc:sevrer}", wantErr: "unknown placeholder ${//svc:sevrer}"},
+		{name: "bazel arg unknown label", prefix: "${", input: "${@@//:missing}", wantErr: "unknown placeholder ${@@//:missing}"},
+		{name: "bazel arg unknown kind", prefix: "${", input: "${adress:@@//:svc}", wantErr: "unknown placeholder ${adress:@@//:svc}"},
+		{name: "bazel arg shell expansion", prefix: "${", input: "${#FOO}", wantErr: "unknown placeholder ${#FOO}"},
+		{name: "bazel arg missing env", prefix: "${", input: "${env:MISSING}", wantErr: "MISSING is not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix := tt.prefix
+			if prefix == "" {
+				prefix = "$${"
+			}
+			e := newExpander(prefix, ports, hosts, "@@//:svc", env)
+
+			got, err := e.expandField("args[0]", tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expand(%q) = %q, %v, want error containing %q", tt.input, got, err, tt.wantErr)
+				}
+				if !strings.HasPrefix(err.Error(), "@@//:svc: args[0]: ") {
+					t.Errorf("error %q does not name the service and field", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expand(%q) failed: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("expand(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+
+	"rules_itest/svclib"
+)
+
+// watchedPaths returns the files that trigger a restart when modified in watch mode:
+// the service specs, and each service's executable and version file.
+func watchedPaths(serviceSpecsPath string, serviceSpecs map[string]svclib.VersionedServiceSpec) ([]string, error) {
+	paths := []string{serviceSpecsPath}
+	for _, spec := range serviceSpecs {
+		if spec.Type == "group" {
+			continue
+		}
+
+		paths = append(paths, spec.Exe)
+		if spec.VersionFile != "" {
+			versionFilePath, err := runfiles.Rlocation(spec.VersionFile)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, versionFilePath)
+		}
+	}
+
+	// Runfiles are typically symlinks into the output tree, which is where Bazel writes new outputs.
+	for i, path := range paths {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = resolved
+	}
+
+	slices.Sort(paths)
+	return slices.Compact(paths), nil
+}
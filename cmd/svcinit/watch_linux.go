@@ -0,0 +1,91 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFiles calls onChange whenever one of paths is rewritten, until the returned func is called.
+// Bazel replaces outputs rather than writing them in place, so we watch the parent directories.
+func watchFiles(paths []string, onChange func(path string)) (func(), error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	watchedNames := map[int32]map[string]string{}
+	watchedDirs := map[string]int32{}
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		wd, ok := watchedDirs[dir]
+		if !ok {
+			rawWd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_CREATE|unix.IN_ATTRIB)
+			if err != nil {
+				unix.Close(fd)
+				return nil, err
+			}
+			wd = int32(rawWd)
+			watchedDirs[dir] = wd
+			watchedNames[wd] = map[string]string{}
+		}
+		watchedNames[wd][filepath.Base(path)] = path
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer unix.Close(fd)
+
+		buf := make([]byte, 64*1024)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			// Poll with a timeout so that we notice when we are asked to stop.
+			n, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, 250)
+			if err == unix.EINTR || (err == nil && n == 0) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Stopped watching for changes: %v", err)
+				return
+			}
+
+			n, err = unix.Read(fd, buf)
+			if err == unix.EINTR || err == unix.EAGAIN {
+				continue
+			}
+			if err != nil {
+				log.Printf("Stopped watching for changes: %v", err)
+				return
+			}
+
+			changed := map[string]bool{}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+				nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+				nameStart := offset + unix.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+				offset = nameStart + nameLen
+
+				if path, ok := watchedNames[wd][name]; ok {
+					changed[path] = true
+				}
+			}
+
+			for path := range changed {
+				onChange(path)
+			}
+		}
+	}()
+
+	return func() { close(done) }, nil
+}
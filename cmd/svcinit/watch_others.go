@@ -0,0 +1,49 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchFiles calls onChange whenever one of paths is rewritten, until the returned func is called.
+// Without inotify, we fall back to polling modification times.
+func watchFiles(paths []string, onChange func(path string)) (func(), error) {
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			for _, path := range paths {
+				info, err := os.Stat(path)
+				if err != nil {
+					// Most likely in the middle of being rewritten.
+					continue
+				}
+				if !info.ModTime().Equal(modTimes[path]) {
+					modTimes[path] = info.ModTime()
+					onChange(path)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }, nil
+}
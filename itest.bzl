@@ -29,6 +29,34 @@ def named_port(label, name):
     """
     return port(_to_relative_named_port(label, name))
 
+def host(label):
+    """This function is used to reference the host that clients should use to reach the auto assigned port of a service.
+
+    It can be used wherever `port` can be used.
+    """
+    return "$${host:%s}" % _to_relative_port(label)
+
+def named_host(label, name):
+    """This function is used to reference the host that clients should use to reach a named port of a service.
+
+    It can be used wherever `named_port` can be used. The host depends on the service's `port_options`.
+    """
+    return host(_to_relative_named_port(label, name))
+
+def address(label):
+    """This function is used to reference the `host:port` address of the auto assigned port of a service.
+
+    It can be used wherever `port` can be used.
+    """
+    return "$${address:%s}" % _to_relative_port(label)
+
+def named_address(label, name):
+    """This function is used to reference the `host:port` address of a named port of a service. IPv6 hosts are bracketed.
+
+    It can be used wherever `named_port` can be used.
+    """
+    return address(_to_relative_named_port(label, name))
+
 def port_alias(label):
     """This function is used to reference the auto assigned port of a service in the `aliases` attribute of an `itest_service_group`.
 
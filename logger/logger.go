@@ -6,7 +6,10 @@ import (
 	"encoding/binary"
 	"io"
 	"log"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 const Reset = "\033[0m"
@@ -101,14 +104,73 @@ func Colorize(s string) string {
 }
 
 func New(prefix string, color string, out io.Writer) io.WriteCloser {
+	return NewWithHistory(prefix, color, out, nil)
+}
+
+// NewWithHistory is like New, but also records every line into history.
+// Nothing is printed while the history is muted.
+func NewWithHistory(prefix string, color string, out io.Writer, history *History) io.WriteCloser {
 	return &Logger{
-		out: log.New(out, color+prefix+Reset, log.Ltime|log.Lmicroseconds|log.Lmsgprefix),
+		out:     log.New(out, color+prefix+Reset, log.Ltime|log.Lmicroseconds|log.Lmsgprefix),
+		history: history,
 	}
 }
 
 type Logger struct {
-	out *log.Logger
-	buf bytes.Buffer
+	out     *log.Logger
+	buf     bytes.Buffer
+	history *History
+}
+
+// History retains the most recent lines logged for a service.
+type History struct {
+	mu       sync.Mutex
+	lines    []string
+	maxLines int
+	muted    bool
+}
+
+func NewHistory(maxLines int) *History {
+	return &History{maxLines: maxLines}
+}
+
+func (h *History) add(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines = append(h.lines, line)
+	if len(h.lines) > h.maxLines {
+		h.lines = slices.Clone(h.lines[len(h.lines)-h.maxLines:])
+	}
+}
+
+// Lines returns up to the n most recent lines, oldest first.
+func (h *History) Lines(n int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n = max(0, min(n, len(h.lines)))
+	return slices.Clone(h.lines[len(h.lines)-n:])
+}
+
+func (h *History) SetMuted(muted bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.muted = muted
+}
+
+func (h *History) Muted() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.muted
+}
+
+func (l *Logger) print(line string) {
+	if l.history != nil {
+		l.history.add(strings.TrimSuffix(line, "\n"))
+		if l.history.Muted() {
+			return
+		}
+	}
+	l.out.Print(line)
 }
 
 func (l *Logger) Write(data []byte) (int, error) {
@@ -121,7 +183,7 @@ func (l *Logger) Write(data []byte) (int, error) {
 				l.buf.Bytes(),
 				data[lastNewline:i+1]...,
 			)
-			l.out.Print(string(line))
+			l.print(string(line))
 			written += len(line)
 			l.buf.Reset()
 			lastNewline = i + 1
@@ -133,6 +195,6 @@ func (l *Logger) Write(data []byte) (int, error) {
 }
 
 func (l *Logger) Close() error {
-	l.out.Print(l.buf.String())
+	l.print(l.buf.String())
 	return nil
 }
@@ -15,13 +15,29 @@ query:enable-reload --@rules_itest//:enable_per_service_reload
 
 `ibazel run --config enable-reload //path/to:target`
 
+Services are only restarted once ibazel reports a successful build; a failed build leaves the running services alone.
+Successful builds that are followed by another build within `--@rules_itest//:ibazel_debounce` (100ms by default)
+are coalesced into a single restart. Every restart logs what changed for each affected service.
+
+Restarts do not require ibazel. When `--@rules_itest//:watch` is set, the service manager watches each service's
+executable and version file (using inotify on Linux, and polling elsewhere), and restarts services when they are
+rebuilt, for example by a plain `bazel build` in another terminal. Combine it with `enable_per_service_reload`
+to also pick up changes to a service's runfiles. Watching has no effect under `bazel test`, which must exit once the
+test is done.
+
 In addition, if the `hot_reloadable` attribute is set on an `itest_service`, the service manager will
 forward the ibazel hot-reload notification over stdin instead of restarting the service.
+Services that close or otherwise use their stdin can be notified with a signal (`hot_reload_signal`)
+or an HTTP request (`hot_reload_http_address`) instead.
+
+By default, only the services whose code or definition changed are restarted. If `--@rules_itest//:restart_dependents`
+is set, restarting a service also restarts every service that transitively depends on it and re-runs every task
+that transitively depends on it. Services are stopped in reverse dependency order and started again in dependency order.
 
 # Service control
 
 The service manager exposes a HTTP server on `http://127.0.0.1:{SVCCTL_PORT}`. It can be used to
-start / stop services during a test run. There are currently 5 API endpoints available.
+start / stop services during a test run. There are currently 6 API endpoints available.
 All of them are GET requests:
 
 1. `/v0/healthcheck?service={label}`: Returns 200 if the service is healthy, 503 otherwise.
@@ -29,10 +45,147 @@ All of them are GET requests:
 3. `/v0/kill?service={label}[&signal={signal}]`: Send kill signal to the service if it is running.
    You can optionally specify the signal to send to the service (valid values: SIGTERM and SIGKILL).
 4. `/v0/wait?service={label}`: Wait for the service to exit and returns the exit code in the body.
-5. `/v0/port?service={label}`: Returns the assigned port for the given label. May be a named port.
+5. `/v0/port?service={label}[&format={format}]`: Returns the assigned port for the given label. May be a named port.
+   Valid formats are `port` (the default), `host` and `address` (`host:port`). See `port_options`.
+6. `/v0/graph[?format={format}]`: Returns the resolved service graph, including each service's type, deferred flag,
+   ports, last measured startup duration and critical path membership. Valid formats are `json` (the default) and `dot`.
+
+The same graph can be written out once the services are up by setting `--@rules_itest//:dump_graph`.
+Under `bazel test`, it is written to `service_graph.dot` and `service_graph.json` in the undeclared test outputs;
+under `bazel run`, the DOT representation is printed.
+
+# Interactive console
+
+When a service group or test is started with `bazel run` from a terminal, the service manager also accepts commands
+on stdin: `status`, `start <service>`, `stop <service>`, `restart <service>`, `logs <service> [n]`, `mute <service>`,
+`unmute <service>` and `ports`. Services may be referred to by their full label or by their target name.
+Type `help` for details. The console is disabled under ibazel, which uses stdin for its own notifications,
+and can be turned off with `--no@rules_itest//:interactive_console`.
+
+# Service discovery
+
+Outside of a one-shot `bazel test`, the service manager registers its session in
+`$RULES_ITEST_SESSION_DIR`, falling back to `$XDG_RUNTIME_DIR/rules_itest/sessions` or `$TMPDIR/rules_itest_sessions`.
+Each session writes a JSON manifest with the target label, the svcinit pid, the svcctl address, all assigned ports,
+`SOCKET_DIR`, `TEST_TMPDIR` and a `ready` flag which is set once all services are healthy (and cleared during reloads).
+A sourceable `.env` file exporting `SVCCTL_ADDRESS`, `SVCCTL_PORT`, `ASSIGNED_PORTS`, `ASSIGNED_HOSTS`, `SOCKET_DIR` and `TEST_TMPDIR`
+is written next to it. Both are removed when the session exits, so multiple concurrent sessions do not interfere.
 
-In `bazel run` mode, the service manager will write the value of `SVCCTL_PORT` to `/tmp/svcctl_port`.
-This can be used in conjunction with the `/v0/port` API to let other tools interact with the managed services.
+The manifest also records the process groups of running services. On Linux, services are killed if the
+service manager dies, but their children may survive, for example when the service manager is SIGKILLed.
+Before assigning ports, a new session for the same target kills any process groups left behind by a
+predecessor which is no longer running, so that they do not keep holding ports.
+
+Use `@rules_itest//cmd/itest_session` to find the most recent live session for a target:
+
+```
+source "$(bazel run @rules_itest//cmd/itest_session -- -env //my:services)"
+bazel run @rules_itest//cmd/itest_session -- -port @@//my:db //my:services
+bazel run @rules_itest//cmd/itest_session -- -list
+```
+
+For backwards compatibility, `bazel run` of a service group also writes the value of `SVCCTL_PORT` to `/tmp/svcctl_port`.
+
+# Substitutions
+
+The `args`, `env`, `health_check_args`, `http_health_check_address`, `hot_reload_http_address` and `working_dir` of
+services and tasks, and the `args` and `env` of `service_test`, can refer to assigned ports and other values with
+placeholders, which are expanded in a single pass before the services start:
+
+- `$${PORT}` and `$${HOST}`: the service's own autoassigned port and address.
+- `$${TMPDIR}` and `$${SOCKET_DIR}`: the directories set up by the service manager.
+- `$${<label>}`: the port assigned to a service, or `$${<label>.<name>}` for a named port.
+- `$${host:<label>}`, `$${address:<label>}` and `$${url:<label>}`: the host, `host:port` and `http://host:port` of a port.
+- `$${env:<name>}`: a variable from the service's own `env`, falling back to the service manager's environment.
+- `$${file:<path>}`: the contents of a file without trailing newlines, as an rlocation path or an absolute path.
+- `$${output:<label>:<key>}`: an output of a task, see below.
+
+Any placeholder can be given a default with `:-`, such as `$${env:LOG_LEVEL:-info}`, which may itself contain
+placeholders. Placeholders that cannot be resolved are an error naming the service and the field. A `$` before a
+placeholder escapes it, so `$$${PORT}` is passed on as the literal `$${PORT}`. In the `args` of a `service_test`,
+where Bazel collapses `$$`, write `$$$${PORT}` for a literal `${PORT}`. Since the args may be meant for a shell, unknown
+placeholders there that name a shell variable, such as `${HOME}` or `${FOO:-bar}`, are passed on as is instead of being
+an error. Other shell expansions, such as `${#FOO}`, must be escaped.
+
+Tasks can hand values such as a generated API key or tenant ID to the services and tests that depend on them by
+writing a JSON object to the file named by `ITEST_OUTPUTS_FILE`, e.g. `{"api_key": "..."}`. Outputs are resolved
+right before each dependent starts, so the dependent must depend on the task. String values are substituted as is,
+and other values as JSON. The file is removed each time the task starts, so reruns never leak stale values.
+
+# Stable ports
+
+Every session normally gets fresh ports, which breaks bookmarks, IDE datasource configs and shell history. With
+`--@rules_itest//:stable_ports`, the assigned ports are saved per target under the user cache directory (e.g.
+`~/.cache/rules_itest/ports`), and later sessions try the same ports first, falling back to new ones when they are taken.
+Ports set through the `.port` flags still take precedence. Stable ports are not used under `bazel test`, or by a
+`service_test` that exits once its test is done.
+
+# Port reservation
+
+Autoassigned ports are released before the services bind them, so concurrent `service_test`s on the same machine may
+pick the same port. Set `--@rules_itest//:port_reservation_dir=/tmp/rules_itest_ports` to reserve every assigned
+port host-wide with a lock file in that directory. Ports reserved by another live service manager are skipped, and
+reservations are released when the owning service manager exits, even if it crashes. Under the sandbox, the directory
+must also be made writable with `--sandbox_writable_path=/tmp/rules_itest_ports`. TCP and UDP ports, as configured with
+`port_options`, are reserved separately.
+
+Collisions with processes outside of rules_itest can still happen. If a service fails to become healthy and its recent
+output says that its address is already in use, the service manager assigns it new ports, updates the substitutions
+for every service that refers to them, restarts those services, and retries. Only autoassigned ports can be reassigned.
+Services are retried up to `--@rules_itest//:bind_failure_retries` times (2 by default).
+
+When a port cannot be bound, for example because a fixed port set through the `.port` flag is taken, the service
+manager reports the pid and command line of the process holding it on Linux, along with the target of the
+session that started it, if any.
+
+# Verifying listening ports
+
+A service that ignores `$${PORT}`, for example by falling back to a default port, can still pass a command health check.
+With `--@rules_itest//:verify_listening_ports=warn` (or `fail`), the service manager inspects the sockets of each
+service and its descendants on Linux once it is healthy. It reports assigned ports that nothing listens on, TCP ports
+the service listens on without having been assigned them, and sockets bound to all interfaces instead of loopback,
+unless a wildcard `host` or the `dual` family was requested in `port_options`.
+
+# Per-service loopback addresses
+
+On Linux, all of `127.0.0.0/8` is routed to loopback. With `--@rules_itest//:per_service_loopback`, every service and
+task gets its own address in a randomly picked `127.x.y.0/24` block, so services that hardcode a well-known port can run
+side by side, and multi-host topologies can be simulated. Autoassigned ports are bound on the service's address.
+A service's own address is available as `$${HOST}`, and any service's address as `$${host:<label>}`.
+`$${address:<label>}` expands to the `host:port` of a port. The addresses are exported as a JSON map in `ASSIGNED_HOSTS`,
+and under `host:<label>` in `ASSIGNED_PORTS`, for example `$($GET_ASSIGNED_PORT_BIN host:@@//label/for:service)`.
+They are also returned by `/v0/port?service={label}&format=host`. HTTP health check and hot reload
+addresses on `127.0.0.1` or `localhost` are pointed at the service's address. For example, three replicas of a server
+can each listen on `$${HOST}:6379`.
+
+# Hermetic environment
+
+By default, services inherit the environment of the service manager, which under `bazel run` is the caller's shell.
+With `--@rules_itest//:hermetic_env`, services and their health checks only receive `PATH`, the runfiles variables,
+`TMPDIR`, `TEST_TMPDIR`, `SOCKET_DIR`, `SVCCTL_PORT`, `ASSIGNED_PORTS`, `ASSIGNED_HOSTS` and `GET_ASSIGNED_PORT_BIN`, plus their `env`.
+Additional variables can be passed through with `--@rules_itest//:env_passthrough=HOME,AWS_PROFILE,JAVA_*`,
+where a trailing `*` matches by prefix.
+
+# Wrappers
+
+A service or the test can be run under a wrapper command, such as a debugger or tracer, without editing BUILD files.
+Wrappers are configured as `label=command args`, separated by `;`, either with `--@rules_itest//:wrap` or with the
+`ITEST_WRAP` environment variable, which does not require a rebuild under `bazel run`. The executable and its args are
+appended to the wrapper, unless it contains an `{exe}` placeholder, in which case the executable is put there instead.
+Use the `service_test`'s own label to wrap the test. For example:
+
+```
+ITEST_WRAP='//services:db=strace -f -o /tmp/db.strace;//services:api=dlv exec {exe} --headless --listen=:2345 --' bazel run //services:all
+```
+
+Health check timeouts are disabled for wrapped services, since they may be waiting on a debugger.
+
+# Leaked processes
+
+Services are stopped by signalling their process group, but daemons that double-fork or call `setsid` escape it.
+On Linux, the service manager is a child subreaper, so such processes are reparented to it instead of init.
+Once all services are stopped, any remaining descendants are reported with their command lines and killed.
+Set `--@rules_itest//:fail_on_leaked_processes` to also fail the test when this happens.
 """
 
 load("@bazel_lib//lib:paths.bzl", "to_rlocation_path")
@@ -56,12 +209,29 @@ def _run_environment(ctx, service_specs_file):
     return {
         # Flags
         "SVCINIT_ALLOW_CONFIGURING_TMPDIR": str(ctx.attr._allow_configuring_tmpdir[BuildSettingInfo].value),
+        "SVCINIT_BIND_FAILURE_RETRIES": str(ctx.attr._bind_failure_retries[BuildSettingInfo].value),
+        "SVCINIT_DUMP_GRAPH": str(ctx.attr._dump_graph[BuildSettingInfo].value),
         "SVCINIT_ENABLE_PER_SERVICE_RELOAD": str(ctx.attr._enable_per_service_reload[BuildSettingInfo].value),
+        "SVCINIT_ENV_PASSTHROUGH": ",".join(ctx.attr._env_passthrough[BuildSettingInfo].value),
+        "SVCINIT_FAIL_ON_LEAKED_PROCESSES": str(ctx.attr._fail_on_leaked_processes[BuildSettingInfo].value),
+        "SVCINIT_HERMETIC_ENV": str(ctx.attr._hermetic_env[BuildSettingInfo].value),
+        "SVCINIT_IBAZEL_DEBOUNCE": ctx.attr._ibazel_debounce[BuildSettingInfo].value,
+        "SVCINIT_INTERACTIVE_CONSOLE": str(ctx.attr._interactive_console[BuildSettingInfo].value),
         "SVCINIT_KEEP_SERVICES_UP": str(ctx.attr._keep_services_up[BuildSettingInfo].value),
+        "SVCINIT_PER_SERVICE_LOOPBACK": str(ctx.attr._per_service_loopback[BuildSettingInfo].value),
+        "SVCINIT_PORT_RESERVATION_DIR": ctx.attr._port_reservation_dir[BuildSettingInfo].value,
+        "SVCINIT_RESTART_DEPENDENTS": str(ctx.attr._restart_dependents[BuildSettingInfo].value),
+        "SVCINIT_STABLE_PORTS": str(ctx.attr._stable_ports[BuildSettingInfo].value),
         "SVCINIT_TERSE_OUTPUT": str(ctx.attr._terse_svcinit_output[BuildSettingInfo].value),
+        "SVCINIT_VERIFY_LISTENING_PORTS": ctx.attr._verify_listening_ports[BuildSettingInfo].value,
+        "SVCINIT_WATCH": str(ctx.attr._watch[BuildSettingInfo].value),
+        "SVCINIT_WRAP": ctx.attr._wrap[BuildSettingInfo].value,
 
         # Specs
         "SVCINIT_SERVICE_SPECS_RLOCATION_PATH": to_rlocation_path(ctx, service_specs_file),
+
+        # Used to register the session for discovery.
+        "SVCINIT_TARGET_LABEL": str(ctx.label),
     }
 
 def _services_runfiles(ctx, services_attr_name = "services"):
@@ -84,12 +254,54 @@ _svcinit_attrs = {
     "_allow_configuring_tmpdir": attr.label(
         default = "//:allow_configuring_tmpdir",
     ),
+    "_bind_failure_retries": attr.label(
+        default = "//:bind_failure_retries",
+    ),
+    "_ibazel_debounce": attr.label(
+        default = "//:ibazel_debounce",
+    ),
+    "_interactive_console": attr.label(
+        default = "//:interactive_console",
+    ),
+    "_dump_graph": attr.label(
+        default = "//:dump_graph",
+    ),
+    "_env_passthrough": attr.label(
+        default = "//:env_passthrough",
+    ),
+    "_fail_on_leaked_processes": attr.label(
+        default = "//:fail_on_leaked_processes",
+    ),
+    "_hermetic_env": attr.label(
+        default = "//:hermetic_env",
+    ),
     "_keep_services_up": attr.label(
         default = "//:keep_services_up",
     ),
+    "_per_service_loopback": attr.label(
+        default = "//:per_service_loopback",
+    ),
+    "_port_reservation_dir": attr.label(
+        default = "//:port_reservation_dir",
+    ),
+    "_restart_dependents": attr.label(
+        default = "//:restart_dependents",
+    ),
+    "_stable_ports": attr.label(
+        default = "//:stable_ports",
+    ),
     "_terse_svcinit_output": attr.label(
         default = "//:terse_svcinit_output",
     ),
+    "_verify_listening_ports": attr.label(
+        default = "//:verify_listening_ports",
+    ),
+    "_watch": attr.label(
+        default = "//:watch",
+    ),
+    "_wrap": attr.label(
+        default = "//:wrap",
+    ),
 }
 
 _itest_binary_attrs = {
@@ -110,6 +322,35 @@ _itest_binary_attrs = {
         providers = [_ServiceGroupInfo],
         doc = "Services/tasks that must be started before this service/task can be started. Can be `itest_service`, `itest_task`, or `itest_service_group`.",
     ),
+    "dep_conditions": attr.label_keyed_string_dict(
+        providers = [_ServiceGroupInfo],
+        doc = """Overrides how far a dependency must progress before this service/task is started. Every key must also be listed in `deps`.
+        Valid values are:
+        - `service_started`: the dependency's process has been started.
+        - `service_healthy`: the dependency has passed its health check (or completed, for tasks). This is the default.""",
+    ),
+    "nice": attr.int(
+        doc = "The nice value to run the binary with, from -20 (highest priority) to 19 (lowest priority). Not supported on Windows.",
+    ),
+    "pty": attr.bool(
+        doc = """If set, the binary is attached to a pseudo-terminal instead of pipes, for tools that only colorize or line-buffer
+        their output, or refuse to start, without one. stdout and stderr are merged. Under `bazel run`, the terminal size is
+        propagated to the pseudo-terminal. Hot reload notifications are written to the terminal's input. Only supported on Linux.""",
+    ),
+    "rlimits": attr.string_dict(
+        doc = """Resource limits to apply to the binary. Valid keys are `NOFILE`, `AS` (in bytes), `CORE` (in bytes) and `NPROC`.
+        Values are either `soft` or `soft:hard`, where each limit is a number or `unlimited`. If only the soft limit is given,
+        the hard limit is raised to match it if needed. The limits are applied before the binary is executed. Only supported on Linux.
+        Example: `rlimits = {"NOFILE": "65536", "CORE": "unlimited"}`""",
+    ),
+    "umask": attr.string(
+        doc = "The umask to run the binary with, as an octal string such as `022`. Not supported on Windows.",
+    ),
+    "working_dir": attr.string(
+        doc = """The working directory to run the binary in. It is created if it does not exist, and relative paths are resolved
+        against the runfiles directory. Supports `$${TMPDIR}` and `$${SOCKET_DIR}` substitutions. Defaults to the runfiles
+        directory. To give the service/task a scratch directory of its own, use e.g. `working_dir = "$${TMPDIR}/db"`.""",
+    ),
 } | _svcinit_attrs
 
 def _compute_env(ctx, underlying_target):
@@ -132,8 +373,42 @@ def _validate_deferred(ctx, deps):
             if dep[_ServiceGroupInfo].deferred:
                 fail("Non-deferred itest_service cannot depend on deferred itest_service: %s depends on %s" % (ctx.label, dep.label))
 
+_DEP_CONDITIONS = ["service_started", "service_healthy"]
+
+def _compute_dep_conditions(ctx):
+    deps = [dep.label for dep in ctx.attr.deps]
+    dep_conditions = {}
+    for dep, condition in ctx.attr.dep_conditions.items():
+        if dep.label not in deps:
+            fail("dep_conditions key %s must also be listed in deps" % dep.label)
+        if condition not in _DEP_CONDITIONS:
+            fail("Invalid dep_conditions value for %s: %s. Valid values are: %s" % (dep.label, condition, ", ".join(_DEP_CONDITIONS)))
+        dep_conditions[str(dep.label)] = condition
+    return dep_conditions
+
+_RLIMITS = ["AS", "CORE", "NOFILE", "NPROC"]
+
+def _validate_rlimit_value(name, value):
+    if value != "unlimited" and not value.isdigit():
+        fail("Invalid rlimits value for %s: %s. Limits must be a number or `unlimited`" % (name, value))
+
+def _validate_process_attributes(ctx):
+    for name, value in ctx.attr.rlimits.items():
+        if name not in _RLIMITS:
+            fail("Invalid rlimits key: %s. Valid keys are: %s" % (name, ", ".join(_RLIMITS)))
+        for limit in value.split(":", 1):
+            _validate_rlimit_value(name, limit)
+
+    if ctx.attr.nice < -20 or ctx.attr.nice > 19:
+        fail("nice must be between -20 and 19, got %d" % ctx.attr.nice)
+
+    umask = ctx.attr.umask
+    if umask and (len(umask) > 4 or not umask.isdigit() or "8" in umask or "9" in umask):
+        fail("umask must be an octal string such as 022, got %s" % umask)
+
 def _itest_binary_impl(ctx, extra_service_spec_kwargs, extra_exe_runfiles = []):
     _validate_deferred(ctx, ctx.attr.deps)
+    _validate_process_attributes(ctx)
 
     exe_runfiles = [ctx.attr.exe.default_runfiles] + extra_exe_runfiles
 
@@ -159,6 +434,12 @@ def _itest_binary_impl(ctx, extra_service_spec_kwargs, extra_exe_runfiles = []):
         args = args,
         env = _compute_env(ctx, ctx.attr.exe),
         deps = [str(dep.label) for dep in ctx.attr.deps],
+        dep_conditions = _compute_dep_conditions(ctx),
+        working_dir = ctx.attr.working_dir,
+        rlimits = ctx.attr.rlimits,
+        nice = ctx.attr.nice,
+        umask = ctx.attr.umask,
+        pty = ctx.attr.pty,
         **extra_service_spec_kwargs
     )
 
@@ -189,6 +470,30 @@ def _validate_duration(name, s):
     if unit not in ["ms", "s", "m", "h", "d"]:
         fail("Invalid unit for %s: %s" % (name, unit))
 
+_PORT_OPTIONS = {
+    "family": ["v4", "v6", "dual"],
+    "host": None,
+    "protocol": ["tcp", "udp"],
+}
+
+def _parse_port_options(ctx):
+    port_names = ctx.attr.named_ports.values()
+    parsed = {}
+    for port_name, options in ctx.attr.port_options.items():
+        if port_name not in port_names:
+            fail("port_options refers to %s, which is not one of the named_ports" % port_name)
+
+        parsed_options = {}
+        for option in options.split(","):
+            key, sep, value = option.partition("=")
+            if not sep or key not in _PORT_OPTIONS:
+                fail("Invalid port option for %s: %s. Options look like `protocol=udp,family=v6,host=::1`" % (port_name, option))
+            if _PORT_OPTIONS[key] and value not in _PORT_OPTIONS[key]:
+                fail("Invalid %s for %s: %s. Valid values are: %s" % (key, port_name, value, ", ".join(_PORT_OPTIONS[key])))
+            parsed_options[key] = value
+        parsed[port_name] = parsed_options
+    return parsed
+
 def _itest_service_impl(ctx):
     _validate_duration("expected_start_duration", ctx.attr.expected_start_duration)
     _validate_duration("health_check_interval", ctx.attr.health_check_interval)
@@ -199,6 +504,18 @@ def _itest_service_impl(ctx):
     if ctx.attr.so_reuseport_aware and not (ctx.attr.autoassign_port or ctx.attr.named_ports):
         fail("SO_REUSEPORT awareness only makes sense when using port autoassignment")
 
+    if (ctx.attr.hot_reload_signal or ctx.attr.hot_reload_http_address) and not ctx.attr.hot_reloadable:
+        fail("hot_reload_signal and hot_reload_http_address only make sense when hot_reloadable is set")
+
+    if ctx.attr.hot_reload_signal and ctx.attr.hot_reload_http_address:
+        fail("Only one of hot_reload_signal and hot_reload_http_address may be set")
+
+    if ctx.attr.lazy and not (ctx.attr.autoassign_port or ctx.attr.named_ports):
+        fail("Lazy services must use port autoassignment, so that the service manager can listen on their behalf")
+
+    if ctx.attr.socket_activation and not (ctx.attr.autoassign_port or ctx.attr.named_ports):
+        fail("Socket activation only makes sense when using port autoassignment")
+
     shutdown_timeout = ctx.attr.shutdown_timeout or ctx.attr._default_shutdown_timeout[BuildSettingInfo].value
 
     extra_service_spec_kwargs = {
@@ -212,7 +529,12 @@ def _itest_service_impl(ctx):
             name: str(port_flag[BuildSettingInfo].value)
             for port_flag, name in ctx.attr.named_ports.items()
         },
+        "port_options": _parse_port_options(ctx),
         "hot_reloadable": ctx.attr.hot_reloadable,
+        "hot_reload_signal": ctx.attr.hot_reload_signal,
+        "hot_reload_http_address": ctx.attr.hot_reload_http_address,
+        "lazy": ctx.attr.lazy,
+        "socket_activation": ctx.attr.socket_activation,
         "expected_start_duration": ctx.attr.expected_start_duration,
         "health_check_interval": ctx.attr.health_check_interval,
         "health_check_timeout": ctx.attr.health_check_timeout,
@@ -256,11 +578,28 @@ _itest_service_attrs = _itest_binary_attrs | {
     "port": attr.label(doc = "Internal"),
     "named_ports": attr.label_keyed_string_dict(
         doc = """For each element of the list, the service manager will pick a free port and assign it to the service.
-        The port's fully-qualified name is the service's fully-qualified label and the port name, separated by a colon.
+        The port's fully-qualified name is the service's fully-qualified label and the port name, separated by a dot.
         For example, a port assigned with `named_ports = ["http_port"]` will be assigned a fully-qualified name of `@@//label/for:service.http_port`.
 
         Named ports are accessible through the service-port mapping. For more details, see `autoassign_port`.""",
     ),
+    "port_options": attr.string_dict(
+        doc = """How to assign each named port, keyed by port name. Values are comma-separated options, for example
+        `port_options = {"dns": "protocol=udp", "grpc": "family=v6"}`.
+
+        - `protocol`: `tcp` (the default) or `udp`.
+        - `family`: `v4` (the default), `v6` or `dual`. Dual-stack ports are bound on both families.
+        - `host`: the address to bind. Defaults to `127.0.0.1` for `v4`, `::1` for `v6` and the wildcard address for `dual`.
+
+        The host that clients should connect to is available as `$${host:<name>}`, and `$${address:<name>}` expands to
+        `host:port`, with IPv6 hosts bracketed. See `named_host` and `named_address`. Wildcard binds are reached over loopback.""",
+    ),
+    "socket_activation": attr.bool(
+        doc = """If set, the service manager keeps the autoassigned ports bound and hands the listening sockets to the service,
+        instead of releasing the ports and letting the service bind them. This eliminates the window in which another process
+        can grab a port, without requiring `so_reuseport_aware`. The sockets are passed using the systemd socket activation protocol,
+        as described in `lazy`, with named ports mapped to fd names. Implied by `lazy`. Not supported on Windows.""",
+    ),
     "so_reuseport_aware": attr.bool(
         doc = """If set, the service manager will not release the autoassigned port. The service binary must use SO_REUSEPORT when binding it.
         This reduces the possibility of port collisions when running many service_tests in parallel, or when code binds port 0 without being
@@ -293,12 +632,32 @@ _itest_service_attrs = _itest_binary_attrs | {
     ),
     "hot_reloadable": attr.bool(
         doc = """If set to True, the service manager will propagate ibazel's reload notification over stdin instead of restarting the service.
+        Use `hot_reload_signal` or `hot_reload_http_address` to notify the service another way.
+        The service is health checked again after every reload.
         See the ruleset docstring for more info on using ibazel""",
     ),
+    "hot_reload_http_address": attr.string(
+        doc = """If set, the service manager will send an HTTP POST request to this address, with the ibazel notification as the body,
+        instead of writing the notification to the service's stdin. Any 2xx response is considered a successful reload.
+        The request times out after 10 seconds. Supports the same substitutions as `http_health_check_address`. Requires `hot_reloadable`.""",
+    ),
+    "hot_reload_signal": attr.string(
+        doc = """If set, the service manager will send this signal to the service's process group
+        instead of writing the ibazel notification to the service's stdin. Requires `hot_reloadable`. Not supported on Windows.""",
+        values = ["", "SIGHUP", "SIGUSR1", "SIGUSR2"],
+    ),
     "http_health_check_address": attr.string(
         doc = """If set, the service manager will send an HTTP request to this address to check if the service came up in a healthy state.
-        This check will be retried until it returns a 200 HTTP code. When used in conjunction with autoassigned ports, `$${@@//label/for:service:port_name}` can be used in the address.
-        Example: `http_health_check_address = "http://127.0.0.1:$${@@//label/for:service:port_name}",`""",
+        This check will be retried until it returns a 200 HTTP code. When used in conjunction with autoassigned ports, `$${@@//label/for:service.port_name}` can be used in the address.
+        Example: `http_health_check_address = "http://127.0.0.1:$${@@//label/for:service.port_name}",`""",
+    ),
+    "lazy": attr.bool(
+        doc = """If set, the service is not started up front. Instead, the service manager listens on its assigned ports and starts it
+        on the first incoming connection, once its deps are up. Connections are queued until then, so dependents are started right away.
+        The listening sockets are handed to the service using the systemd socket activation protocol: they are passed as fds 3 and up,
+        with `LISTEN_FDS`, `LISTEN_PID` and `LISTEN_FDNAMES` set. The fd name is the port name, or `default` for the autoassigned port.
+        The service must accept connections on the inherited sockets rather than binding the ports itself.
+        Requires `autoassign_port` or `named_ports`. Not supported on Windows.""",
     ),
     "shutdown_signal": attr.string(
         default = "SIGTERM",
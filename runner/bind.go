@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"strings"
+)
+
+// BindError is returned by StartAll when a service failed to become healthy because it could not
+// bind its port, typically because something else grabbed the port after it was assigned.
+type BindError struct {
+	Label string
+	Err   error
+}
+
+func (e *BindError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Messages printed by common runtimes when bind(2) fails with EADDRINUSE, lowercased.
+var bindFailurePatterns = []string{
+	"address already in use",
+	"address in use",
+	"eaddrinuse",
+	"only one usage of each socket address",
+}
+
+// failedToBind returns true if the service's recent output shows that it could not bind its port.
+func (s *ServiceInstance) failedToBind() bool {
+	for _, line := range s.Logs(50) {
+		line = strings.ToLower(line)
+		for _, pattern := range bindFailurePatterns {
+			if strings.Contains(line, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package runner
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// On Linux, svcinit is a child subreaper, so it adopts orphaned descendants and has to reap them. Children that
+// were started through an exec.Cmd must be left to cmd.Wait though, or Wait fails. Commands are started under
+// childrenMu and recorded right away, so the reaper never mistakes one of them for an adopted orphan.
+var (
+	childrenMu    sync.RWMutex
+	ownedChildren sync.Map
+)
+
+// StartCmd starts cmd and records its process as owned by cmd.Wait. Call ReleaseCmd once Wait returned.
+func StartCmd(cmd *exec.Cmd) error {
+	childrenMu.RLock()
+	defer childrenMu.RUnlock()
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	ownedChildren.Store(cmd.Process.Pid, true)
+	return nil
+}
+
+// ReleaseCmd forgets a command started by StartCmd, after it was waited for.
+func ReleaseCmd(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		ownedChildren.Delete(cmd.Process.Pid)
+	}
+}
+
+// WithChildrenLocked calls fn while no commands are being started. Any child of svcinit that isOwned does not
+// report was adopted, and can be reaped by fn.
+func WithChildrenLocked(fn func(isOwned func(pid int) bool)) {
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+
+	fn(func(pid int) bool {
+		_, ok := ownedChildren.Load(pid)
+		return ok
+	})
+}
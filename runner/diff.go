@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"rules_itest/svclib"
+)
+
+// describeChanges returns a concise, human-readable summary of how a service definition changed.
+func describeChanges(old, new svclib.VersionedServiceSpec) []string {
+	var changes []string
+
+	if old.Version != new.Version {
+		changes = append(changes, fmt.Sprintf("version: %s -> %s",
+			strings.TrimSpace(old.Version), strings.TrimSpace(new.Version)))
+	}
+
+	if !slices.Equal(old.Args, new.Args) {
+		changes = append(changes, fmt.Sprintf("args: %v -> %v", old.Args, new.Args))
+	}
+
+	if envChanges := describeEnvChanges(old.Env, new.Env); envChanges != "" {
+		changes = append(changes, "env: "+envChanges)
+	}
+
+	oldRest, newRest := old, new
+	oldRest.Version, newRest.Version = "", ""
+	oldRest.Args, newRest.Args = nil, nil
+	oldRest.Env, newRest.Env = nil, nil
+	if !reflect.DeepEqual(oldRest, newRest) {
+		changes = append(changes, "other attributes changed")
+	}
+
+	return changes
+}
+
+// describeEnvChanges lists the env vars that were added, removed or changed, without their values,
+// which may be secrets.
+func describeEnvChanges(old, new map[string]string) string {
+	keys := make([]string, 0, len(old)+len(new))
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var changes []string
+	for _, k := range keys {
+		oldValue, inOld := old[k]
+		newValue, inNew := new[k]
+		switch {
+		case !inOld:
+			changes = append(changes, "+"+k)
+		case !inNew:
+			changes = append(changes, "-"+k)
+		case oldValue != newValue:
+			changes = append(changes, "~"+k)
+		}
+	}
+	return strings.Join(changes, " ")
+}
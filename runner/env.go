@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"os"
+	"strings"
+)
+
+// In hermetic mode, services only see a minimal base env plus an explicit allowlist,
+// so that stray variables in a developer's shell do not change their behavior.
+var shouldUseHermeticEnv = os.Getenv("SVCINIT_HERMETIC_ENV") == "True"
+var envPassthrough = strings.FieldsFunc(os.Getenv("SVCINIT_ENV_PASSTHROUGH"), func(r rune) bool { return r == ',' })
+
+var hermeticBaseEnv = []string{
+	"PATH",
+	// Windows processes commonly fail to start without it.
+	"SYSTEMROOT",
+
+	// Runfiles
+	"JAVA_RUNFILES",
+	"RUNFILES_DIR",
+	"RUNFILES_MANIFEST_FILE",
+
+	// Set up by svcinit
+	"ASSIGNED_HOSTS",
+	"ASSIGNED_PORTS",
+	"GET_ASSIGNED_PORT_BIN",
+	"SOCKET_DIR",
+	"SVCCTL_PORT",
+	"TEST_TMPDIR",
+	"TMPDIR",
+}
+
+// baseEnv returns the env that services and their health checks start from, before any per-service env is applied.
+func baseEnv() []string {
+	// Note, this leaks the caller's env into the service, so it's not hermetic.
+	// For `bazel test`, Bazel is already sanitizing the env, so it's fine.
+	// For `bazel run`, there is no expectation of hermeticity, and it can be nice to use env to control behavior.
+	if !shouldUseHermeticEnv {
+		return os.Environ()
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if isAllowedInHermeticEnv(name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+func isAllowedInHermeticEnv(name string) bool {
+	for _, allowed := range hermeticBaseEnv {
+		if name == allowed {
+			return true
+		}
+	}
+	for _, pattern := range envPassthrough {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
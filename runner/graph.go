@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"rules_itest/svclib"
+)
+
+// Graph is a snapshot of the resolved service graph, suitable for rendering.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Deferred bool   `json:"deferred"`
+	// Keyed by the fully qualified port name, same as ASSIGNED_PORTS.
+	Ports         map[string]string `json:"ports,omitempty"`
+	StartDuration string            `json:"start_duration,omitempty"`
+	CriticalPath  bool              `json:"critical_path"`
+}
+
+// An edge points from a service to one of its dependencies.
+type GraphEdge struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Condition string `json:"condition"`
+}
+
+func (r *Runner) Graph(ports svclib.Ports) Graph {
+	r.mu.Lock()
+	criticalPath := r.criticalPath
+	r.mu.Unlock()
+
+	instances := r.instances()
+	labels := sortedLabels(instances)
+
+	g := Graph{
+		Nodes: make([]GraphNode, 0, len(labels)),
+	}
+	for _, label := range labels {
+		instance := instances[label]
+
+		node := GraphNode{
+			Label:        label,
+			Type:         instance.Type,
+			Deferred:     instance.Deferred,
+			Ports:        servicePorts(instance.VersionedServiceSpec, ports),
+			CriticalPath: slices.Contains(criticalPath, label),
+		}
+		if duration := instance.StartDuration(); duration != 0 {
+			node.StartDuration = duration.String()
+		}
+		g.Nodes = append(g.Nodes, node)
+
+		deps := slices.Clone(instance.Deps)
+		slices.Sort(deps)
+		for _, dep := range deps {
+			condition := instance.DepConditions[dep]
+			if condition == "" {
+				condition = svclib.DepConditionHealthy
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: label, To: dep, Condition: condition})
+		}
+	}
+
+	return g
+}
+
+func servicePorts(s svclib.VersionedServiceSpec, ports svclib.Ports) map[string]string {
+	var names []string
+	if s.AutoassignPort {
+		names = append(names, s.Label)
+	}
+	for portName := range s.NamedPorts {
+		names = append(names, s.Label+"."+portName)
+	}
+	for portName := range s.PortAliases {
+		if portName == "" {
+			names = append(names, s.Label)
+		} else {
+			names = append(names, s.Label+"."+portName)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	servicePorts := make(map[string]string, len(names))
+	for _, name := range names {
+		if port, ok := ports[name]; ok {
+			servicePorts[name] = port
+		}
+	}
+	return servicePorts
+}
+
+func (g Graph) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (g Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph services {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [fontname=\"monospace\"];\n")
+
+	for _, node := range g.Nodes {
+		lines := []string{node.Label, node.Type}
+
+		portNames := make([]string, 0, len(node.Ports))
+		for name := range node.Ports {
+			portNames = append(portNames, name)
+		}
+		slices.Sort(portNames)
+		for _, name := range portNames {
+			lines = append(lines, name+" = "+node.Ports[name])
+		}
+
+		if node.StartDuration != "" {
+			lines = append(lines, "started in "+node.StartDuration)
+		}
+
+		attrs := []string{
+			"label=" + dotQuote(strings.Join(lines, "\n")),
+			"shape=" + dotShape(node.Type),
+		}
+		if node.Deferred {
+			attrs = append(attrs, "style=dashed")
+		}
+		if node.CriticalPath {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(node.Label), strings.Join(attrs, ", "))
+	}
+
+	for _, edge := range g.Edges {
+		attrs := ""
+		if edge.Condition != svclib.DepConditionHealthy {
+			attrs = fmt.Sprintf(" [label=%s, style=dashed]", dotQuote(edge.Condition))
+		}
+		fmt.Fprintf(&b, "  %s -> %s%s;\n", dotQuote(edge.From), dotQuote(edge.To), attrs)
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotShape(serviceType string) string {
+	switch serviceType {
+	case "task":
+		return "ellipse"
+	case "group":
+		return "folder"
+	default:
+		return "box"
+	}
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sys/unix"
+
+	"rules_itest/svclib"
+)
+
+// waitForConnection blocks until a connection is pending on any of the sockets, without accepting it.
+func waitForConnection(ctx context.Context, sockets []svclib.Socket) error {
+	fds := make([]unix.PollFd, 0, len(sockets))
+	for _, socket := range sockets {
+		fds = append(fds, unix.PollFd{Fd: int32(socket.File.Fd()), Events: unix.POLLIN})
+	}
+
+	for ctx.Err() == nil {
+		n, err := unix.Poll(fds, 250)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"rules_itest/svclib"
+)
+
+func waitForConnection(ctx context.Context, sockets []svclib.Socket) error {
+	return fmt.Errorf("lazy services are not supported on windows")
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"rules_itest/svclib"
+)
+
+// resolveOutputs substitutes the outputs of tasks that the spec refers to. The outputs are only known once
+// the tasks have run, so this happens each time the service starts.
+func resolveOutputs(spec svclib.VersionedServiceSpec) (svclib.VersionedServiceSpec, error) {
+	spec.Args = slices.Clone(spec.Args)
+	spec.HealthCheckArgs = slices.Clone(spec.HealthCheckArgs)
+	spec.Env = maps.Clone(spec.Env)
+
+	var err error
+	resolve := func(s *string) {
+		if err == nil {
+			*s, err = svclib.ResolveOutputs(*s)
+		}
+	}
+
+	resolve(&spec.HttpHealthCheckAddress)
+	resolve(&spec.HotReloadHttpAddress)
+	resolve(&spec.WorkingDir)
+	for i := range spec.Args {
+		resolve(&spec.Args[i])
+	}
+	for i := range spec.HealthCheckArgs {
+		resolve(&spec.HealthCheckArgs[i])
+	}
+	for k, v := range spec.Env {
+		resolve(&v)
+		spec.Env[k] = v
+	}
+	if err != nil {
+		return spec, fmt.Errorf("%s: %w", spec.Label, err)
+	}
+	return spec, nil
+}
+
+// prepareOutputsFile removes the outputs of a previous run of the task, so that dependents never see stale values.
+func prepareOutputsFile(label string) error {
+	path := svclib.OutputsFile(label)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
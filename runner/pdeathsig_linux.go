@@ -0,0 +1,18 @@
+//go:build linux
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setPdeathsig makes sure the service is killed if svcinit dies without getting a chance to stop it,
+// for example when it is SIGKILLed. Note that this only covers the direct child; anything else left
+// in its process group is reaped by the next svcinit for the same target.
+func setPdeathsig(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+}
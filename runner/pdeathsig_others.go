@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+import "os/exec"
+
+// setPdeathsig is a no-op, parent death signals are only available on Linux.
+func setPdeathsig(cmd *exec.Cmd) {}
@@ -29,3 +29,16 @@ func killGroup(cmd *exec.Cmd, sig syscall.Signal) error {
 	}
 	return syscall.Kill(pid, sig)
 }
+
+func reloadSignal(name string) (syscall.Signal, error) {
+	switch name {
+	case "SIGHUP":
+		return syscall.SIGHUP, nil
+	case "SIGUSR1":
+		return syscall.SIGUSR1, nil
+	case "SIGUSR2":
+		return syscall.SIGUSR2, nil
+	default:
+		return 0, fmt.Errorf("unsupported hot reload signal %s", name)
+	}
+}
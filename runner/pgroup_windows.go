@@ -3,6 +3,7 @@
 package runner
 
 import (
+	"fmt"
 	"os/exec"
 	"syscall"
 )
@@ -24,3 +25,7 @@ func killGroup(cmd *exec.Cmd, _ syscall.Signal) error {
 	// Windows doesn't have process groups, so just kill the process.
 	return cmd.Process.Kill()
 }
+
+func reloadSignal(name string) (syscall.Signal, error) {
+	return 0, fmt.Errorf("reloading with %s is not supported on windows", name)
+}
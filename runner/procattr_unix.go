@@ -0,0 +1,114 @@
+//go:build unix
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"rules_itest/svclib"
+)
+
+// The umask, nice value and rlimits must already be in place when the service execs. The umask is process-wide,
+// so svcinit cannot change it around a fork without affecting concurrent starts, and the others can only be changed
+// for a process that is already running. Services that set any of them are therefore started through svcinit
+// itself, which applies them to its own process and then execs the service, see MaybeApplyProcessAttributes.
+const processAttributesEnv = "SVCINIT_PROCESS_ATTRIBUTES"
+
+type processAttributes struct {
+	Umask   int               `json:"umask"`
+	Nice    int               `json:"nice"`
+	Rlimits map[string]string `json:"rlimits"`
+}
+
+// MaybeApplyProcessAttributes turns the current process into the service it was asked to start, if it was started
+// as a process attributes trampoline. It must be called first thing in main, before svcinit does anything else.
+func MaybeApplyProcessAttributes() {
+	if encoded, ok := os.LookupEnv(processAttributesEnv); ok {
+		execWithProcessAttributes(encoded)
+	}
+}
+
+// processAttributesTrampoline returns the command to start exe with the process attributes from the service spec,
+// along with the env the trampoline needs. It returns exe and args unchanged if the spec sets none.
+func processAttributesTrampoline(exe string, args []string, s svclib.ServiceSpec) (string, []string, []string, error) {
+	if s.Umask == "" && s.Nice == 0 && len(s.Rlimits) == 0 {
+		return exe, args, nil, nil
+	}
+
+	attributes := processAttributes{
+		Umask:   -1,
+		Nice:    s.Nice,
+		Rlimits: s.Rlimits,
+	}
+	if s.Umask != "" {
+		mask, err := strconv.ParseUint(s.Umask, 8, 32)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("invalid umask %q: %w", s.Umask, err)
+		}
+		attributes.Umask = int(mask)
+	}
+	// Fail here rather than in the trampoline, where the error is only logged as the service's output.
+	err := checkRlimits(s.Rlimits)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	svcinit, err := os.Executable()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return svcinit, append([]string{exe}, args...), []string{processAttributesEnv + "=" + string(encoded)}, nil
+}
+
+// execWithProcessAttributes applies the attributes to the current process and execs os.Args[1:]. It never returns.
+func execWithProcessAttributes(encoded string) {
+	// The nice value belongs to the thread on Linux, and is inherited by exec from the thread that calls it.
+	runtime.LockOSThread()
+
+	err := applyProcessAttributes(encoded)
+	var path string
+	if err == nil {
+		path, err = exec.LookPath(os.Args[1])
+	}
+	if err == nil {
+		os.Unsetenv(processAttributesEnv)
+		err = syscall.Exec(path, os.Args[1:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "failed to start %s: %v\n", os.Args[1], err)
+	os.Exit(127)
+}
+
+func applyProcessAttributes(encoded string) error {
+	var attributes processAttributes
+	err := json.Unmarshal([]byte(encoded), &attributes)
+	if err != nil {
+		return err
+	}
+
+	if attributes.Umask >= 0 {
+		syscall.Umask(attributes.Umask)
+	}
+
+	err = setRlimits(0, attributes.Rlimits)
+	if err != nil {
+		return err
+	}
+
+	if attributes.Nice != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, attributes.Nice)
+		if err != nil {
+			return fmt.Errorf("setting nice value %d: %w", attributes.Nice, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package runner
+
+import (
+	"fmt"
+
+	"rules_itest/svclib"
+)
+
+// MaybeApplyProcessAttributes does nothing on windows, where services are never started through a trampoline.
+func MaybeApplyProcessAttributes() {}
+
+// processAttributesTrampoline rejects the process attributes, which are not supported on windows.
+func processAttributesTrampoline(exe string, args []string, s svclib.ServiceSpec) (string, []string, []string, error) {
+	if len(s.Rlimits) > 0 || s.Nice != 0 || s.Umask != "" {
+		return "", nil, nil, fmt.Errorf("rlimits, nice and umask are not supported on windows")
+	}
+	return exe, args, nil, nil
+}
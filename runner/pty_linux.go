@@ -0,0 +1,135 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// attachPty runs cmd with a pseudo-terminal as its stdin, stdout and stderr, and copies its output to out.
+// The returned stdin writes to the terminal. The returned func must be called once the command has been
+// started (or failed to start), to release our end of the terminal and start copying output.
+func attachPty(cmd *exec.Cmd, out io.Writer) (io.WriteCloser, func(started bool), error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slave, err := openPtySlave(master)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// The service becomes the leader of a new session (and process group) with the terminal as its
+	// controlling terminal. setsid fails for process group leaders, so we must not call setpgid as well.
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	afterStart := func(started bool) {
+		slave.Close()
+		if !started {
+			master.Close()
+			return
+		}
+
+		trackPty(master)
+		go func() {
+			// Reading fails with EIO once every process has closed the other end.
+			io.Copy(out, master)
+			untrackPty(master)
+			master.Close()
+		}()
+	}
+	return master, afterStart, nil
+}
+
+func openPtySlave(master *os.File) (*os.File, error) {
+	fd := int(master.Fd())
+	err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unlocking pty: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		return nil, fmt.Errorf("getting pty number: %w", err)
+	}
+
+	slave, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't translate newlines into CRLF or echo input (i.e. hot reload notifications) back into the logs.
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Oflag &^= unix.ONLCR
+		termios.Lflag &^= unix.ECHO
+		err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		slave.Close()
+		return nil, fmt.Errorf("configuring pty: %w", err)
+	}
+
+	setPtySize(master)
+	return slave, nil
+}
+
+var (
+	ptysMu          sync.Mutex
+	ptys            = map[*os.File]bool{}
+	watchResizeOnce sync.Once
+)
+
+func trackPty(master *os.File) {
+	ptysMu.Lock()
+	ptys[master] = true
+	ptysMu.Unlock()
+
+	watchResizeOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGWINCH)
+		go func() {
+			for range ch {
+				ptysMu.Lock()
+				for master := range ptys {
+					setPtySize(master)
+				}
+				ptysMu.Unlock()
+			}
+		}()
+	})
+}
+
+func untrackPty(master *os.File) {
+	ptysMu.Lock()
+	delete(ptys, master)
+	ptysMu.Unlock()
+}
+
+// setPtySize propagates our terminal's size to the pty, falling back to 80x24 if we are not attached to one.
+func setPtySize(master *os.File) {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || size.Row == 0 || size.Col == 0 {
+		size = &unix.Winsize{Row: 24, Col: 80}
+	}
+	unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, size)
+}
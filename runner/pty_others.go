@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+func attachPty(cmd *exec.Cmd, out io.Writer) (io.WriteCloser, func(started bool), error) {
+	return nil, nil, fmt.Errorf("pty is only supported on linux")
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var rlimitResources = map[string]int{
+	"AS":     unix.RLIMIT_AS,
+	"CORE":   unix.RLIMIT_CORE,
+	"NOFILE": unix.RLIMIT_NOFILE,
+	"NPROC":  unix.RLIMIT_NPROC,
+}
+
+// checkRlimits validates limits before they are passed to setRlimits.
+func checkRlimits(rlimits map[string]string) error {
+	for name, value := range rlimits {
+		if _, ok := rlimitResources[name]; !ok {
+			return fmt.Errorf("unsupported rlimit %s", name)
+		}
+		softStr, hardStr, hasHard := strings.Cut(value, ":")
+		if _, err := parseRlimit(softStr); err != nil {
+			return fmt.Errorf("rlimit %s: %w", name, err)
+		}
+		if hasHard {
+			if _, err := parseRlimit(hardStr); err != nil {
+				return fmt.Errorf("rlimit %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setRlimits applies limits of the form `soft` or `soft:hard`, where either may be `unlimited`.
+// If only the soft limit is given, the hard limit is raised to match it if needed.
+func setRlimits(pid int, rlimits map[string]string) error {
+	for name, value := range rlimits {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return fmt.Errorf("unsupported rlimit %s", name)
+		}
+
+		var current unix.Rlimit
+		err := unix.Prlimit(pid, resource, nil, &current)
+		if err != nil {
+			return fmt.Errorf("getting rlimit %s: %w", name, err)
+		}
+
+		softStr, hardStr, hasHard := strings.Cut(value, ":")
+		limit := unix.Rlimit{Max: current.Max}
+		limit.Cur, err = parseRlimit(softStr)
+		if err != nil {
+			return fmt.Errorf("rlimit %s: %w", name, err)
+		}
+		if hasHard {
+			limit.Max, err = parseRlimit(hardStr)
+			if err != nil {
+				return fmt.Errorf("rlimit %s: %w", name, err)
+			}
+		} else if limit.Cur > limit.Max {
+			limit.Max = limit.Cur
+		}
+
+		err = unix.Prlimit(pid, resource, &limit, nil)
+		if err != nil {
+			return fmt.Errorf("setting rlimit %s to %s: %w", name, value, err)
+		}
+	}
+	return nil
+}
+
+func parseRlimit(s string) (uint64, error) {
+	if s == "unlimited" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,16 @@
+//go:build unix && !linux
+
+package runner
+
+import "fmt"
+
+func checkRlimits(rlimits map[string]string) error {
+	return setRlimits(0, rlimits)
+}
+
+func setRlimits(pid int, rlimits map[string]string) error {
+	if len(rlimits) > 0 {
+		return fmt.Errorf("rlimits are only supported on linux")
+	}
+	return nil
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"reflect"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +28,10 @@ import (
 var shouldUseProcessGroups = runtime.GOOS != "windows" && os.Getenv("BAZEL_TEST") != "1"
 var terseOutput = os.Getenv("SVCINIT_TERSE_OUTPUT") == "True"
 
+// If set, restarting a service also restarts everything that transitively depends on it,
+// so that e.g. connection pools are re-established and migrations re-run.
+var shouldRestartDependents = os.Getenv("SVCINIT_RESTART_DEPENDENTS") == "True"
+
 type ServiceSpecs = map[string]svclib.VersionedServiceSpec
 
 type Runner struct {
@@ -32,12 +39,17 @@ type Runner struct {
 	serviceSpecs ServiceSpecs
 
 	serviceInstances map[string]*ServiceInstance
+
+	mu             sync.Mutex
+	criticalPath   []string
+	pendingReloads map[string][]byte
 }
 
 func New(ctx context.Context, serviceSpecs ServiceSpecs) (*Runner, error) {
 	r := &Runner{
 		ctx:              ctx,
 		serviceInstances: map[string]*ServiceInstance{},
+		pendingReloads:   map[string][]byte{},
 	}
 	err := r.UpdateSpecs(serviceSpecs, nil)
 	if err != nil {
@@ -61,25 +73,36 @@ func (r *Runner) StartAll(serviceErrCh chan error) ([]topological.Task, error) {
 			return nil
 		}
 
-		if terseOutput {
-			log.Printf("Starting %s\n", colorize(service.VersionedServiceSpec))
-		} else {
-			log.Printf("Starting %s %v\n", colorize(service.VersionedServiceSpec), service.cmd.Args[1:])
-		}
-
-		startErr := service.Start(ctx)
-		if startErr != nil {
-			return startErr
-		}
+		// Tasks are re-run by every restart, unless restart_dependents is set, in which case
+		// they are only re-run along with a dependency that was restarted.
+		if service.isUp() && (service.Type != "task" || shouldRestartDependents) {
+			r.mu.Lock()
+			ibazelCmd, shouldReload := r.pendingReloads[service.Label]
+			delete(r.pendingReloads, service.Label)
+			r.mu.Unlock()
+
+			// Nothing to do for services that are still up from a previous run and tasks that already succeeded.
+			if !shouldReload {
+				return nil
+			}
 
-		go func() {
-			err := service.Wait()
-			if err != nil && !service.Killed() {
-				serviceErrCh <- fmt.Errorf(colorize(service.VersionedServiceSpec) + " exited with error: " + err.Error())
+			log.Printf("Reloading %s\n", colorize(service.VersionedServiceSpec))
+			if err := service.Reload(ctx, ibazelCmd); err != nil {
+				return err
 			}
-		}()
+		} else if service.Lazy {
+			// Connections queue up in the socket's backlog until the service starts, so dependents need not wait.
+			r.startLazily(service, serviceErrCh)
+			return nil
+		} else {
+			err := startService(ctx, service, serviceErrCh)
+			if err != nil {
+				return err
+			}
+		}
 
-		if service.VersionedServiceSpec.HealthCheckTimeout != "" {
+		// Wrapped services may be sitting at a debugger prompt, so give them as long as they need.
+		if service.VersionedServiceSpec.HealthCheckTimeout != "" && !service.wrapped {
 			timeout, err := time.ParseDuration(service.VersionedServiceSpec.HealthCheckTimeout)
 			if err != nil {
 				log.Printf("failed to parse health check timeout, falling back to no timeout: %v", err)
@@ -88,17 +111,110 @@ func (r *Runner) StartAll(serviceErrCh chan error) ([]topological.Task, error) {
 			ctx = timeoutCtx
 			defer cancel()
 		}
-		return service.WaitUntilHealthy(ctx)
+		err := service.WaitUntilHealthy(ctx)
+		if err != nil && service.failedToBind() {
+			return &BindError{Label: service.Label, Err: err}
+		}
+		if err != nil {
+			return err
+		}
+		return service.verifyPorts()
 	})
 	starter := topological.NewRunner(tasks)
 	err := starter.Run(r.ctx)
 
-	return starter.CriticalPath(), err
+	criticalPath := starter.CriticalPath()
+	criticalPathLabels := make([]string, 0, len(criticalPath))
+	for _, task := range criticalPath {
+		criticalPathLabels = append(criticalPathLabels, task.Key())
+	}
+	r.mu.Lock()
+	r.criticalPath = criticalPathLabels
+	r.mu.Unlock()
+
+	return criticalPath, err
+}
+
+// startService starts the service and reports on serviceErrCh if it exits uncleanly, unless it was stopped on purpose.
+func startService(ctx context.Context, service *ServiceInstance, serviceErrCh chan error) error {
+	startErr := service.Start(ctx)
+	if startErr != nil {
+		return startErr
+	}
+
+	// Logged once started, since the args may contain task outputs that are only resolved by Start.
+	if terseOutput {
+		log.Printf("Starting %s\n", colorize(service.VersionedServiceSpec))
+	} else {
+		log.Printf("Starting %s %v\n", colorize(service.VersionedServiceSpec), service.cmd.Args[1:])
+	}
+	topological.MarkStarted(ctx)
+
+	service.exitHandled.Add(1)
+	go func() {
+		defer service.exitHandled.Done()
+		err := service.Wait()
+		if err != nil && !service.Killed() {
+			serviceErrCh <- fmt.Errorf(colorize(service.VersionedServiceSpec) + " exited with error: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// StartInstance starts a single service or task outside of StartAll, such as a deferred service requested through
+// svcctl or the console. Its deps must already have been started, and are waited for until they are healthy.
+// Like StartAll, it does not wait for the instance itself to become healthy.
+func (r *Runner) StartInstance(ctx context.Context, service *ServiceInstance, serviceErrCh chan error) error {
+	for _, dep := range service.Deps {
+		depService := r.GetInstance(dep)
+		if depService == nil {
+			return fmt.Errorf("dependency %q not found", dep)
+		}
+		// Nothing else starts deferred deps, so waiting for one that is not running would hang.
+		if depService.Deferred && !depService.isRunning() && !depService.isUp() {
+			return fmt.Errorf("deferred dependency %s is not running", colorize(depService.VersionedServiceSpec))
+		}
+		if err := depService.WaitUntilHealthy(ctx); err != nil {
+			return fmt.Errorf("failed to wait for %s until healthy: %w", colorize(depService.VersionedServiceSpec), err)
+		}
+	}
+
+	return startService(ctx, service, serviceErrCh)
+}
+
+// startLazily starts the service once the first connection arrives on one of its sockets.
+// Its deps have already been started by the time this is called.
+func (r *Runner) startLazily(service *ServiceInstance, serviceErrCh chan error) {
+	ctx, ok := service.awaitConnection(r.ctx)
+	if !ok {
+		// Still waiting from a previous StartAll.
+		return
+	}
+
+	log.Printf("Deferring %s until the first connection\n", colorize(service.VersionedServiceSpec))
+	go func() {
+		defer service.doneAwaitingConnection(ctx)
+
+		err := waitForConnection(ctx, service.Sockets)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = startService(r.ctx, service, serviceErrCh)
+		}
+		if err == nil {
+			err = service.WaitUntilHealthy(r.ctx)
+		}
+		if err != nil {
+			serviceErrCh <- fmt.Errorf("%s failed to start lazily: %w", colorize(service.VersionedServiceSpec), err)
+		}
+	}()
 }
 
 func (r *Runner) StopAll() (map[string]*os.ProcessState, error) {
 	tasks := allTasks(r.serviceInstances, func(ctx context.Context, service *ServiceInstance) error {
-		if service.Type == "group" || service.Deferred {
+		// Deferred services are stopped too if they were started through svcctl or the console.
+		if service.Type == "group" || (service.Deferred && !service.hasStarted()) {
 			return nil
 		}
 		log.Printf("Stopping %s\n", colorize(service.VersionedServiceSpec))
@@ -110,36 +226,87 @@ func (r *Runner) StopAll() (map[string]*os.ProcessState, error) {
 	states := make(map[string]*os.ProcessState)
 
 	for _, serviceInstance := range r.serviceInstances {
-		if serviceInstance.Type == "group" || serviceInstance.Deferred {
+		if serviceInstance.Type == "group" {
 			continue
 		}
-		states[serviceInstance.Label] = serviceInstance.ProcessState()
+		// Lazy and deferred services may never have been started.
+		if state := serviceInstance.ProcessState(); state != nil {
+			states[serviceInstance.Label] = state
+		}
 	}
 
 	return states, err
 }
 
+// instances returns a snapshot of the service instances.
+// UpdateSpecs is the only writer of r.serviceInstances and holds r.mu while it mutates the map. StartAll and StopAll
+// run on the same goroutine as UpdateSpecs, so they read the map directly. Everything else, such as svcctl, the
+// console and the graph, runs concurrently with restarts and must go through here.
+func (r *Runner) instances() map[string]*ServiceInstance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return maps.Clone(r.serviceInstances)
+}
+
 func (r *Runner) GetStartDurations() map[string]time.Duration {
 	durations := make(map[string]time.Duration)
 
-	for _, serviceInstance := range r.serviceInstances {
-		durations[serviceInstance.Label] = serviceInstance.startDuration
+	for _, serviceInstance := range r.instances() {
+		durations[serviceInstance.Label] = serviceInstance.StartDuration()
 	}
 
 	return durations
 }
 
 func (r *Runner) GetInstance(label string) *ServiceInstance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	return r.serviceInstances[label]
 }
 
+// Labels returns the labels of all services, tasks and groups, sorted.
+func (r *Runner) Labels() []string {
+	return sortedLabels(r.instances())
+}
+
+func sortedLabels(instances map[string]*ServiceInstance) []string {
+	labels := make([]string, 0, len(instances))
+	for label := range instances {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+	return labels
+}
+
+// ProcessGroups returns the process group ids of all running services and tasks.
+// It returns nil if services are not placed in their own process groups.
+func (r *Runner) ProcessGroups() []int {
+	if !shouldUseProcessGroups {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pgids []int
+	for _, instance := range r.serviceInstances {
+		instance.mu.Lock()
+		if instance.cmd != nil && instance.cmd.Process != nil && !instance.done {
+			pgids = append(pgids, instance.cmd.Process.Pid)
+		}
+		instance.mu.Unlock()
+	}
+	slices.Sort(pgids)
+	return pgids
+}
+
 type updateActions struct {
 	toStopLabels   []string
 	toStartLabels  []string
 	toReloadLabels []string
 }
 
-func computeUpdateActions(currentServices, newServices ServiceSpecs) updateActions {
+func computeUpdateActions(currentServices, newServices ServiceSpecs, restartDependents bool) updateActions {
 	actions := updateActions{}
 
 	// Check if existing services need a reload, a restart, or a shutdown.
@@ -155,6 +322,9 @@ func computeUpdateActions(currentServices, newServices ServiceSpecs) updateActio
 		// But that should not be a common use case, so it's not worth the complexity.
 		if !reflect.DeepEqual(service, newService) {
 			log.Printf(colorize(service) + " definition or code has changed, restarting...")
+			for _, change := range describeChanges(service, newService) {
+				log.Printf("  %s: %s\n", colorize(service), change)
+			}
 			if service.HotReloadable && reflect.DeepEqual(service.ServiceSpec, newService.ServiceSpec) {
 				// The only difference is the Version. Trust the service that
 				// it prefers to receive the ibazel reload command.
@@ -167,6 +337,10 @@ func computeUpdateActions(currentServices, newServices ServiceSpecs) updateActio
 		}
 	}
 
+	if restartDependents {
+		propagateRestarts(&actions, currentServices, newServices)
+	}
+
 	// Handle new services
 	for label := range newServices {
 		if _, ok := currentServices[label]; !ok {
@@ -177,31 +351,99 @@ func computeUpdateActions(currentServices, newServices ServiceSpecs) updateActio
 	return actions
 }
 
+// propagateRestarts adds the transitive dependents of every restarted service to the restart set.
+func propagateRestarts(actions *updateActions, currentServices, newServices ServiceSpecs) {
+	dependents := map[string][]string{}
+	for label, service := range newServices {
+		for _, dep := range service.Deps {
+			dependents[dep] = append(dependents[dep], label)
+		}
+	}
+
+	restarting := map[string]bool{}
+	for _, label := range actions.toStartLabels {
+		restarting[label] = true
+	}
+
+	queue := slices.Clone(actions.toStartLabels)
+	for len(queue) > 0 {
+		label := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range dependents[label] {
+			if restarting[dependent] {
+				continue
+			}
+			restarting[dependent] = true
+			queue = append(queue, dependent)
+
+			service, ok := currentServices[dependent]
+			if !ok {
+				// New services get started anyway.
+				continue
+			}
+
+			if service.Type != "group" {
+				log.Printf("%s depends on %s, restarting...", colorize(service), colorize(newServices[label]))
+			}
+
+			// A hot reload is not enough if a dependency went away underneath the service.
+			actions.toReloadLabels = slices.DeleteFunc(actions.toReloadLabels, func(l string) bool {
+				return l == dependent
+			})
+			actions.toStopLabels = append(actions.toStopLabels, dependent)
+			actions.toStartLabels = append(actions.toStartLabels, dependent)
+		}
+	}
+}
+
 func (r *Runner) UpdateSpecs(serviceSpecs ServiceSpecs, ibazelCmd []byte) error {
-	updateActions := computeUpdateActions(r.serviceSpecs, serviceSpecs)
+	updateActions := computeUpdateActions(r.serviceSpecs, serviceSpecs, shouldRestartDependents)
 
+	// Stop in reverse topological order, so that dependents never observe their dependencies going away.
+	toStop := make(map[string]bool, len(updateActions.toStopLabels))
 	for _, label := range updateActions.toStopLabels {
-		serviceInstance := r.serviceInstances[label]
-		if serviceInstance.Type == "group" {
-			continue
+		toStop[label] = true
+	}
+	tasks := allTasks(r.serviceInstances, func(ctx context.Context, service *ServiceInstance) error {
+		if !toStop[service.Label] || service.Type == "group" {
+			return nil
+		}
+		log.Printf("Stopping %s\n", colorize(service.VersionedServiceSpec))
+		if err := service.Stop(); err != nil {
+			log.Printf("Failed to stop %s: %v\n", colorize(service.VersionedServiceSpec), err)
 		}
-		serviceInstance.Stop()
+		return nil
+	})
+	err := topological.NewReversedRunner(tasks).Run(r.ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, label := range updateActions.toStopLabels {
 		delete(r.serviceInstances, label)
 	}
 
 	for _, label := range updateActions.toStartLabels {
 		var err error
-		r.serviceInstances[label], err = prepareServiceInstance(r.ctx, serviceSpecs[label])
+		r.serviceInstances[label], err = prepareServiceInstance(serviceSpecs[label])
 		if err != nil {
 			return err
 		}
 	}
 
+	// The reload itself happens in StartAll, so that the service is health checked again afterwards.
 	for _, label := range updateActions.toReloadLabels {
-		_, err := r.serviceInstances[label].stdin.Write(ibazelCmd)
+		spec, err := resolveOutputs(serviceSpecs[label])
 		if err != nil {
 			return err
 		}
+		r.serviceInstances[label].VersionedServiceSpec = serviceSpecs[label]
+		r.serviceInstances[label].setResolvedSpec(spec)
+		r.pendingReloads[label] = ibazelCmd
 	}
 
 	r.serviceSpecs = serviceSpecs
@@ -222,7 +464,7 @@ func (r *Runner) UpdateSpecsAndRestart(
 	return r.StartAll(serviceErrCh)
 }
 
-func prepareServiceInstance(ctx context.Context, s svclib.VersionedServiceSpec) (*ServiceInstance, error) {
+func prepareServiceInstance(s svclib.VersionedServiceSpec) (*ServiceInstance, error) {
 	if s.Type == "group" {
 		return &ServiceInstance{
 			VersionedServiceSpec: s,
@@ -230,36 +472,59 @@ func prepareServiceInstance(ctx context.Context, s svclib.VersionedServiceSpec)
 		}, nil
 	}
 
-	instance := &ServiceInstance{
+	// The cmd is only built by Start, since the spec may refer to task outputs that are not known yet.
+	cmd := &exec.Cmd{}
+	return &ServiceInstance{
 		VersionedServiceSpec: s,
-	}
-
-	err := initializeServiceCmd(ctx, instance)
-	if err != nil {
-		return nil, err
-	}
-
-	return instance, nil
+		cmd:                  cmd,
+		history:              logger.NewHistory(1000),
+		waitErrFn:            sync.OnceValue(cmd.Wait),
+	}, nil
 }
 
-func initializeServiceCmd(ctx context.Context, instance *ServiceInstance) error {
-	s := instance.VersionedServiceSpec
+// initializeServiceCmd builds the cmd that starts the service, from its spec with task outputs resolved.
+func initializeServiceCmd(ctx context.Context, instance *ServiceInstance, s svclib.VersionedServiceSpec) error {
 
-	cmd := exec.CommandContext(ctx, s.Exe, s.Args...)
-	// Note, this leaks the caller's env into the service, so it's not hermetic.
-	// For `bazel test`, Bazel is already sanitizing the env, so it's fine.
-	// For `bazel run`, there is no expectation of hermeticity, and it can be nice to use env to control behavior.
-	cmd.Env = os.Environ()
+	exe, args := s.Exe, s.Args
+	if len(s.Sockets) > 0 {
+		exe, args = socketActivationTrampoline(exe, args)
+	}
+	exe, args, wrapped := WrapCommand(s.Label, exe, args)
+	if wrapped {
+		log.Printf("Running %s under %s\n", colorize(s), strings.Join(append([]string{exe}, args...), " "))
+	}
+	exe, args, attributesEnv, err := processAttributesTrampoline(exe, args, s.ServiceSpec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.Label, err)
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	if s.WorkingDir != "" {
+		err := os.MkdirAll(s.WorkingDir, 0755)
+		if err != nil {
+			return err
+		}
+		cmd.Dir = s.WorkingDir
+	}
+	cmd.Env = baseEnv()
 	for k, v := range s.Env {
 		cmd.Env = append(cmd.Env, k+"="+v)
 	}
-	cmd.Stdout = logger.New(s.Label+"> ", s.Color, os.Stdout)
-	cmd.Stderr = logger.New(s.Label+"> ", s.Color, os.Stderr)
+	cmd.Env = append(cmd.Env, attributesEnv...)
+	if len(s.Sockets) > 0 {
+		passSockets(cmd, s.Sockets)
+	}
+	if instance.history == nil {
+		instance.history = logger.NewHistory(1000)
+	}
+	cmd.Stdout = logger.NewWithHistory(s.Label+"> ", s.Color, os.Stdout, instance.history)
+	cmd.Stderr = logger.NewWithHistory(s.Label+"> ", s.Color, os.Stderr, instance.history)
 
 	if shouldUseProcessGroups {
 		setPgid(cmd)
 	}
 
+	setPdeathsig(cmd)
+
 	// Even if a child process exits, Wait will block until the I/O pipes are closed.
 	// They may have been forwarded to an orphaned child, so we disable that behavior to unblock exit.
 	if s.Type == "service" && !s.Deferred {
@@ -268,15 +533,32 @@ func initializeServiceCmd(ctx context.Context, instance *ServiceInstance) error
 	}
 
 	instance.cmd = cmd
+	instance.wrapped = wrapped
 	instance.killed = false
-	instance.startErrFn = sync.OnceValue(cmd.Start)
+	instance.startErrFn = sync.OnceValue(func() error {
+		// The pty is only opened here, since a cmd may be replaced before it is ever started.
+		afterStart := func(started bool) {}
+		if s.Pty {
+			stdin, attached, err := attachPty(cmd, cmd.Stdout)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.Label, err)
+			}
+			instance.stdin = stdin
+			afterStart = attached
+		}
+		err := StartCmd(cmd)
+		afterStart(err == nil)
+		return err
+	})
 	instance.waitErrFn = sync.OnceValue(func() error {
 		res := cmd.Wait()
+		ReleaseCmd(cmd)
 		instance.SetDone()
 		return res
 	})
 
-	if s.HotReloadable {
+	// With a pty, hot reload notifications are written to the terminal instead.
+	if s.HotReloadable && s.HotReloadSignal == "" && s.HotReloadHttpAddress == "" && !s.Pty {
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
 			return err
@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -20,20 +21,30 @@ import (
 
 type ServiceInstance struct {
 	svclib.VersionedServiceSpec
-	stdin io.WriteCloser
-	cmd   *exec.Cmd
+	stdin   io.WriteCloser
+	cmd     *exec.Cmd
+	history *logger.History
+	// Set if the service runs under a wrapper such as a debugger.
+	wrapped bool
+	// Set while a lazy service is waiting for its first connection.
+	awaitingConnection    context.Context
+	cancelAwaitConnection context.CancelFunc
 
 	startTime     time.Time
 	startDuration time.Duration
 
 	startErrFn func() error
 	waitErrFn  func() error
+	// Done once startService has handled the exit of the process.
+	exitHandled sync.WaitGroup
 
 	mu                   sync.Mutex
 	runErr               error
 	killed               bool
 	healthcheckAttempted bool
 	done                 bool
+	// The spec with task outputs substituted into it, which happens each time the service starts.
+	resolvedSpec svclib.VersionedServiceSpec
 }
 
 func (s *ServiceInstance) Start(ctx context.Context) error {
@@ -41,8 +52,23 @@ func (s *ServiceInstance) Start(ctx context.Context) error {
 		return fmt.Errorf("%s is already running", s.Colorize(s.Label))
 	}
 
+	// Starting a lazy service explicitly (e.g. through svcctl) means we no longer wait for a connection.
+	s.stopAwaitingConnection()
+
+	spec, err := resolveOutputs(s.VersionedServiceSpec)
+	if err != nil {
+		return err
+	}
+	s.setResolvedSpec(spec)
+
+	if s.Type == "task" {
+		if err := prepareOutputsFile(s.Label); err != nil {
+			return err
+		}
+	}
+
 	// If the process has finished running, we need to reinitialize the cmd.
-	if err := initializeServiceCmd(ctx, s); err != nil {
+	if err := initializeServiceCmd(ctx, s, spec); err != nil {
 		return err
 	}
 	s.mu.Lock()
@@ -51,6 +77,41 @@ func (s *ServiceInstance) Start(ctx context.Context) error {
 	return s.startErrFn()
 }
 
+// Reload asks a running hot-reloadable service to pick up new code, using the mechanism
+// configured on the service: a signal, an HTTP POST, or forwarding the ibazel command over stdin.
+func (s *ServiceInstance) Reload(ctx context.Context, ibazelCmd []byte) error {
+	s.mu.Lock()
+	s.startTime = time.Now()
+	s.healthcheckAttempted = false
+	s.mu.Unlock()
+
+	switch {
+	case s.HotReloadSignal != "":
+		signal, err := reloadSignal(s.HotReloadSignal)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.Label, err)
+		}
+		return killGroup(s.cmd, signal)
+	case s.HotReloadHttpAddress != "":
+		req, err := http.NewRequestWithContext(ctx, "POST", s.getResolvedSpec().HotReloadHttpAddress, bytes.NewReader(ibazelCmd))
+		if err != nil {
+			return err
+		}
+		resp, err := reloadHttpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s: reload request failed: %w", s.Label, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s: reload request failed: %s", s.Label, resp.Status)
+		}
+		return nil
+	default:
+		_, err := s.stdin.Write(ibazelCmd)
+		return err
+	}
+}
+
 func (s *ServiceInstance) WaitUntilHealthy(ctx context.Context) error {
 	defer func() {
 		s.mu.Lock()
@@ -116,21 +177,28 @@ var httpClient = http.Client{
 	Timeout: 50 * time.Millisecond,
 }
 
+// The reload request is not covered by the health check timeout, so a service that accepts the connection but
+// never replies would otherwise block every later reload.
+var reloadHttpClient = http.Client{
+	Timeout: 10 * time.Second,
+}
+
 func (s *ServiceInstance) HealthCheck(ctx context.Context, expectedStartDuration time.Duration) bool {
 	coloredLabel := s.Colorize(s.Label)
 	shouldSilence := s.startTime.Add(expectedStartDuration).After(time.Now())
+	spec := s.getResolvedSpec()
 
 	isHealthy := true
 	var err error
-	if s.HttpHealthCheckAddress != "" {
-		httpHealthCheckReq, err := http.NewRequestWithContext(ctx, "GET", s.HttpHealthCheckAddress, nil)
+	if spec.HttpHealthCheckAddress != "" {
+		httpHealthCheckReq, err := http.NewRequestWithContext(ctx, "GET", spec.HttpHealthCheckAddress, nil)
 		if err != nil {
 			log.Printf("Failed to construct healthcheck request for %s: %v\n", coloredLabel, err)
 			return false
 		}
 
 		if !s.HealthcheckAttempted() || !shouldSilence {
-			log.Printf("HTTP Healthchecking %s (pid %d) : %s\n", coloredLabel, s.Pid(), s.HttpHealthCheckAddress)
+			log.Printf("HTTP Healthchecking %s (pid %d) : %s\n", coloredLabel, s.Pid(), spec.HttpHealthCheckAddress)
 		}
 
 		logFunc := log.Printf
@@ -160,11 +228,15 @@ func (s *ServiceInstance) HealthCheck(ctx context.Context, expectedStartDuration
 			if terseOutput {
 				log.Printf("CMD Healthchecking %s\n", coloredLabel)
 			} else {
-				log.Printf("CMD Healthchecking %s (pid %d) : %s %v\n", coloredLabel, s.Pid(), s.Colorize(s.HealthCheckLabel), strings.Join(s.HealthCheckArgs, " "))
+				log.Printf("CMD Healthchecking %s (pid %d) : %s %v\n", coloredLabel, s.Pid(), s.Colorize(s.HealthCheckLabel), strings.Join(spec.HealthCheckArgs, " "))
 			}
 		}
 
-		cmd := exec.CommandContext(ctx, s.ServiceSpec.HealthCheck, s.HealthCheckArgs...)
+		cmd := exec.CommandContext(ctx, s.ServiceSpec.HealthCheck, spec.HealthCheckArgs...)
+		cmd.Env = baseEnv()
+		for k, v := range spec.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
 		if shouldSilence {
 			cmd.Stdout = io.Discard
 			cmd.Stderr = io.Discard
@@ -172,7 +244,11 @@ func (s *ServiceInstance) HealthCheck(ctx context.Context, expectedStartDuration
 			cmd.Stdout = logger.New(s.Label+"? ", s.Color, os.Stdout)
 			cmd.Stderr = logger.New(s.Label+"? ", s.Color, os.Stderr)
 		}
-		err = cmd.Run()
+		err = StartCmd(cmd)
+		if err == nil {
+			err = cmd.Wait()
+			ReleaseCmd(cmd)
+		}
 		if err != nil {
 			cmd.Stdout.Write([]byte(err.Error()))
 			isHealthy = false
@@ -203,6 +279,18 @@ func (s *ServiceInstance) StartDuration() time.Duration {
 	return s.startDuration
 }
 
+func (s *ServiceInstance) getResolvedSpec() svclib.VersionedServiceSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolvedSpec
+}
+
+func (s *ServiceInstance) setResolvedSpec(spec svclib.VersionedServiceSpec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolvedSpec = spec
+}
+
 func (s *ServiceInstance) Error() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -224,6 +312,21 @@ func (s *ServiceInstance) Stop() error {
 	return s.StopWithSignal(signal)
 }
 
+// StopQuietly stops the service like Stop, but it counts as killed even if it already exited on its own, so its exit
+// is never reported as a crash. It returns once the exit has been handled.
+func (s *ServiceInstance) StopQuietly() error {
+	s.mu.Lock()
+	s.killed = true
+	s.mu.Unlock()
+
+	err := s.Stop()
+	if err != nil {
+		return err
+	}
+	s.exitHandled.Wait()
+	return nil
+}
+
 func isGone(err error) bool {
 	if errors.Is(err, os.ErrProcessDone) {
 		return true
@@ -233,7 +336,41 @@ func isGone(err error) bool {
 	return errors.As(err, &errno) && errnoMeansProcessGone(errno)
 }
 
+// awaitConnection marks a lazy service as waiting for its first connection. It returns false if it already is.
+// The returned context is cancelled when the service is stopped.
+func (s *ServiceInstance) awaitConnection(ctx context.Context) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.awaitingConnection != nil {
+		return nil, false
+	}
+
+	s.awaitingConnection, s.cancelAwaitConnection = context.WithCancel(ctx)
+	return s.awaitingConnection, true
+}
+
+// doneAwaitingConnection must be called once the wait started by awaitConnection is over.
+func (s *ServiceInstance) doneAwaitingConnection(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.awaitingConnection == ctx {
+		s.cancelAwaitConnection()
+		s.awaitingConnection, s.cancelAwaitConnection = nil, nil
+	}
+}
+
+func (s *ServiceInstance) stopAwaitingConnection() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancelAwaitConnection != nil {
+		s.cancelAwaitConnection()
+		s.awaitingConnection, s.cancelAwaitConnection = nil, nil
+	}
+}
+
 func (s *ServiceInstance) StopWithSignal(signal syscall.Signal) error {
+	s.stopAwaitingConnection()
+
 	if s.cmd.Process == nil {
 		return nil
 	}
@@ -316,6 +453,51 @@ func (s *ServiceInstance) Wait() error {
 	return err
 }
 
+// Logs returns up to the n most recent lines of output from the service.
+func (s *ServiceInstance) Logs(n int) []string {
+	if s.history == nil {
+		return nil
+	}
+	return s.history.Lines(n)
+}
+
+// SetMuted controls whether the service's output is printed. It is still recorded for Logs.
+func (s *ServiceInstance) SetMuted(muted bool) {
+	if s.history != nil {
+		s.history.SetMuted(muted)
+	}
+}
+
+// Status returns a short human-readable description of the instance's state.
+func (s *ServiceInstance) Status() string {
+	if s.Type == "group" {
+		return "-"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.cmd == nil || s.cmd.Process == nil:
+		if s.Deferred {
+			return "deferred"
+		}
+		if s.awaitingConnection != nil {
+			return "waiting for connection"
+		}
+		return "not started"
+	case s.killed:
+		return "stopped"
+	case !s.done:
+		return "running"
+	case s.cmd.ProcessState != nil && s.cmd.ProcessState.Success():
+		return "exited"
+	case s.cmd.ProcessState != nil:
+		return "failed (" + s.cmd.ProcessState.String() + ")"
+	default:
+		return "exited"
+	}
+}
+
 func (s *ServiceInstance) Pid() int {
 	return s.cmd.Process.Pid
 }
@@ -331,6 +513,12 @@ func (s *ServiceInstance) Killed() bool {
 	return s.killed
 }
 
+func (s *ServiceInstance) hasStarted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmd != nil && s.cmd.Process != nil
+}
+
 func (s *ServiceInstance) isDone() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -346,6 +534,20 @@ func (s *ServiceInstance) isRunning() bool {
 		!s.killed
 }
 
+// Returns true if a service is running, or if a task has already completed successfully.
+func (s *ServiceInstance) isUp() bool {
+	if s.Type != "task" {
+		return s.isRunning()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done &&
+		!s.killed &&
+		s.cmd.ProcessState != nil &&
+		s.cmd.ProcessState.Success()
+}
+
 func (s *ServiceInstance) SetDone() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
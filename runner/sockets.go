@@ -0,0 +1,28 @@
+package runner
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"rules_itest/svclib"
+)
+
+// socketActivationTrampoline runs the service through a shell, so that LISTEN_PID can be set to the pid
+// the service will actually have. `exec` keeps the pid, and we cannot know it before forking.
+func socketActivationTrampoline(exe string, args []string) (string, []string) {
+	return "/bin/sh", append([]string{"-c", `LISTEN_PID=$$; export LISTEN_PID; exec "$0" "$@"`, exe}, args...)
+}
+
+// passSockets hands the sockets to the command as fds 3 and up, following the systemd socket activation protocol.
+func passSockets(cmd *exec.Cmd, sockets []svclib.Socket) {
+	names := make([]string, 0, len(sockets))
+	for _, socket := range sockets {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, socket.File)
+		names = append(names, socket.Name)
+	}
+	cmd.Env = append(cmd.Env,
+		"LISTEN_FDS="+strconv.Itoa(len(sockets)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+	)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"rules_itest/runner/topological"
+	"rules_itest/svclib"
 )
 
 type RunFunc func(ctx context.Context, service *ServiceInstance) error
@@ -35,6 +36,15 @@ func (st *topoTask) Dependents() []topological.Task {
 	return allTasks
 }
 
+func (st *topoTask) DependencyCondition(dep topological.Task) topological.Condition {
+	switch st.serviceInstance.DepConditions[dep.Key()] {
+	case svclib.DepConditionStarted:
+		return topological.ConditionStarted
+	default:
+		return topological.ConditionCompleted
+	}
+}
+
 func (st *topoTask) Duration() time.Duration {
 	return st.serviceInstance.StartDuration()
 }
@@ -16,6 +16,34 @@ type Task interface {
 	StartTime() time.Time
 }
 
+// Condition describes how far a dependency must progress before its dependents are released.
+type Condition int
+
+const (
+	// The dependency's Run returned successfully. This is the default for every edge.
+	ConditionCompleted Condition = iota
+	// The dependency reported that it has started, see MarkStarted.
+	ConditionStarted
+)
+
+// Tasks can implement ConditionalTask if some of their dependency edges should release them
+// at a different point than the dependency's completion.
+type ConditionalTask interface {
+	DependencyCondition(dep Task) Condition
+}
+
+type progressKey struct{}
+
+type progressFunc func(condition Condition)
+
+// MarkStarted may be called from a Task's Run to release dependents that only
+// require the task to have started.
+func MarkStarted(ctx context.Context) {
+	if report, ok := ctx.Value(progressKey{}).(progressFunc); ok {
+		report(ConditionStarted)
+	}
+}
+
 type Runner interface {
 	Run(ctx context.Context) error
 	CriticalPath() []Task
@@ -32,6 +60,7 @@ type runner struct {
 	tasks      []Task
 	tasksByKey map[string]Task
 	completed  map[string]struct{}
+	started    map[string]struct{}
 	die        bool
 	err        error
 }
@@ -50,6 +79,7 @@ func NewRunner(tasks []Task) Runner {
 		tasks:      tasks,
 		tasksByKey: tasksByKey,
 		completed:  make(map[string]struct{}),
+		started:    make(map[string]struct{}),
 	}
 }
 
@@ -130,17 +160,49 @@ func uniqueDeps(tasks []Task) []Task {
 	return deps
 }
 
+func dependencyCondition(task Task, dep Task) Condition {
+	if conditional, ok := task.(ConditionalTask); ok {
+		return conditional.DependencyCondition(dep)
+	}
+	return ConditionCompleted
+}
+
+func (ts *runner) reached(key string, condition Condition) bool {
+	switch condition {
+	case ConditionStarted:
+		if _, ok := ts.started[key]; ok {
+			return true
+		}
+		// Completing implies having started.
+		_, ok := ts.completed[key]
+		return ok
+	default:
+		_, ok := ts.completed[key]
+		return ok
+	}
+}
+
 func (ts *runner) ready(task Task) bool {
-	// A task is ready if all its dependencies are marked completed
+	// A task is ready if all its dependencies have reached the condition required by their edge.
 	// Must be called while holding cv.L.
 	for _, dep := range task.Dependents() {
-		if _, ok := ts.completed[dep.Key()]; !ok {
+		if !ts.reached(dep.Key(), dependencyCondition(task, dep)) {
 			return false
 		}
 	}
 	return true
 }
 
+func (ts *runner) reportProgress(task Task, condition Condition) {
+	ts.cv.L.Lock()
+	defer ts.cv.L.Unlock()
+
+	if condition == ConditionStarted {
+		ts.started[task.Key()] = struct{}{}
+	}
+	ts.cv.Broadcast()
+}
+
 func (ts *runner) nextTask() Task {
 	// Find the next task to run by looping over tasks and checking if it
 	// is ready. If nothing is ready we wait on the CV. I had a real
@@ -186,7 +248,10 @@ func (ts *runner) worker(ctx context.Context, id int) {
 		}
 		ts.cv.L.Unlock()
 
-		performErr := task.Run(ctx)
+		taskCtx := context.WithValue(ctx, progressKey{}, progressFunc(func(condition Condition) {
+			ts.reportProgress(task, condition)
+		}))
+		performErr := task.Run(taskCtx)
 		ts.cv.L.Lock()
 		if performErr != nil {
 			ts.setErr(performErr)
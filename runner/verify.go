@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// One of "off", "warn" or "fail".
+var verifyListeningPorts = os.Getenv("SVCINIT_VERIFY_LISTENING_PORTS")
+
+// listeningSocket is a socket that a service listens on, or a bound UDP socket.
+type listeningSocket struct {
+	Protocol string
+	Port     string
+	Wildcard bool
+}
+
+// verifyPorts checks that a healthy service listens on the ports it was assigned, and only on loopback.
+// Services that ignore `$${PORT}` can otherwise pass a command health check while listening elsewhere.
+func (s *ServiceInstance) verifyPorts() error {
+	if verifyListeningPorts == "" || verifyListeningPorts == "off" || s.Type != "service" {
+		return nil
+	}
+
+	pid := s.Pid()
+	if pid == 0 {
+		return nil
+	}
+	sockets, err := listeningSockets(pid)
+	if err != nil {
+		log.Printf("Could not verify the ports of %s: %v\n", colorize(s.VersionedServiceSpec), err)
+		return nil
+	}
+
+	var problems []string
+	assigned := map[listeningSocket]bool{}
+	wildcardRequested := map[listeningSocket]bool{}
+	for _, port := range s.AssignedPorts {
+		assigned[listeningSocket{Protocol: port.Protocol, Port: port.Port}] = true
+		if net.ParseIP(port.BindHost).IsUnspecified() {
+			wildcardRequested[listeningSocket{Protocol: port.Protocol, Port: port.Port}] = true
+		}
+
+		found := false
+		for _, socket := range sockets {
+			if socket.Protocol == port.Protocol && socket.Port == port.Port {
+				found = true
+			}
+		}
+		if !found {
+			name := "autoassigned port"
+			if port.Name != "" {
+				name = "port " + port.Name
+			}
+			problems = append(problems, fmt.Sprintf("does not listen on its %s (%s %s)", name, port.Protocol, port.Port))
+		}
+	}
+
+	for _, socket := range sockets {
+		// Clients also use unconnected UDP sockets, so only TCP listeners are known to be servers.
+		if socket.Protocol == "tcp" && !assigned[listeningSocket{Protocol: socket.Protocol, Port: socket.Port}] {
+			problems = append(problems, fmt.Sprintf("listens on %s port %s, which was not assigned to it", socket.Protocol, socket.Port))
+		}
+		if socket.Wildcard && !wildcardRequested[listeningSocket{Protocol: socket.Protocol, Port: socket.Port}] {
+			problems = append(problems, fmt.Sprintf("listens on all interfaces instead of loopback for %s port %s", socket.Protocol, socket.Port))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("%s %s", colorize(s.VersionedServiceSpec), strings.Join(problems, ", "))
+	if verifyListeningPorts == "fail" {
+		return err
+	}
+	log.Printf("WARNING: %v\n", err)
+	return nil
+}
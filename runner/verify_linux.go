@@ -0,0 +1,124 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// listeningSockets returns the sockets that pid and its descendants listen on.
+// Descendants are found through their parent pids, since services are not always in their own process group.
+func listeningSockets(pid int) ([]listeningSocket, error) {
+	inodes := map[string]bool{}
+	for _, p := range processTree(pid) {
+		fds, err := filepath.Glob("/proc/" + strconv.Itoa(p) + "/fd/*")
+		if err != nil {
+			return nil, err
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(fd)
+			if err == nil && strings.HasPrefix(target, "socket:[") {
+				inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] = true
+			}
+		}
+	}
+
+	var sockets []listeningSocket
+	for _, protocol := range []string{"tcp", "udp"} {
+		for _, table := range []string{protocol, protocol + "6"} {
+			found, err := readSocketTable("/proc/net/"+table, protocol, inodes)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, socket := range found {
+				// Dual-stack sockets show up in both tables.
+				if !slices.Contains(sockets, socket) {
+					sockets = append(sockets, socket)
+				}
+			}
+		}
+	}
+	return sockets, nil
+}
+
+// readSocketTable returns the listening TCP sockets, or unconnected UDP sockets, in a /proc/net table
+// that belong to one of the inodes. Each line looks like `sl local_address rem_address st ... inode`,
+// with addresses as hex `ADDR:PORT`.
+func readSocketTable(path string, protocol string, inodes map[string]bool) ([]listeningSocket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sockets []listeningSocket
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || !inodes[fields[9]] {
+			continue
+		}
+
+		const tcpListen, udpUnconnected = "0A", "07"
+		if (protocol == "tcp" && fields[3] != tcpListen) || (protocol == "udp" && fields[3] != udpUnconnected) {
+			continue
+		}
+
+		localAddress, localPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(localPort, 16, 16)
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, listeningSocket{
+			Protocol: protocol,
+			Port:     strconv.FormatUint(port, 10),
+			Wildcard: strings.Trim(localAddress, "0") == "",
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// processTree returns pid and all of its descendants.
+func processTree(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return []int{pid}
+	}
+
+	children := map[int][]int{}
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name is in parens and may contain spaces, so skip past it first.
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err == nil {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree
+}
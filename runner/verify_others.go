@@ -0,0 +1,9 @@
+//go:build !linux
+
+package runner
+
+import "errors"
+
+func listeningSockets(pid int) ([]listeningSocket, error) {
+	return nil, errors.ErrUnsupported
+}
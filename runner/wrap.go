@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// Wrappers prefix a service's or the test's command line with another command, such as `dlv exec`,
+// `strace -f`, `rr record` or `valgrind`. They are configured with `label=command args;label=command args`,
+// first from the wrap flag and then from ITEST_WRAP, which can be changed without rebuilding.
+// If the wrapper contains `{exe}`, the executable is placed there instead of after the wrapper's args.
+var wrappers = parseWrappers(os.Getenv("SVCINIT_WRAP") + ";" + os.Getenv("ITEST_WRAP"))
+
+func parseWrappers(s string) map[string][]string {
+	wrappers := map[string][]string{}
+	for _, entry := range strings.Split(s, ";") {
+		label, wrapper, ok := strings.Cut(entry, "=")
+		fields := strings.Fields(wrapper)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		wrappers[normalizeLabel(strings.TrimSpace(label))] = fields
+	}
+	return wrappers
+}
+
+func normalizeLabel(label string) string {
+	if strings.HasPrefix(label, "//") {
+		return "@@" + label
+	}
+	return label
+}
+
+// WrapCommand returns the command line to run for label, taking any configured wrapper into account.
+// The returned bool is true if the command was wrapped.
+func WrapCommand(label string, exe string, args []string) (string, []string, bool) {
+	wrapper, ok := wrappers[normalizeLabel(label)]
+	if !ok {
+		return exe, args, false
+	}
+
+	wrapped := slices.Clone(wrapper[1:])
+	if i := slices.Index(wrapped, "{exe}"); i >= 0 {
+		wrapped[i] = exe
+	} else {
+		wrapped = append(wrapped, exe)
+	}
+	return wrapper[0], append(wrapped, args...), true
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package session
+
+import (
+	"errors"
+	"syscall"
+)
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	// EPERM means the process exists but belongs to somebody else.
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func processGroupAlive(pgid int) bool {
+	return processAlive(-pgid)
+}
+
+func killProcessGroup(pgid int) error {
+	err := syscall.Kill(-pgid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
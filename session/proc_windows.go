@@ -0,0 +1,24 @@
+//go:build windows
+
+package session
+
+import "os"
+
+func processAlive(pid int) bool {
+	// On Windows, FindProcess opens a handle to the process, which fails if it does not exist.
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+func processGroupAlive(pgid int) bool {
+	return false
+}
+
+func killProcessGroup(pgid int) error {
+	// Services do not run in their own process groups on Windows.
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package session records running svcinit sessions in a registry directory, so that
+// external scripts and IDEs can discover the services started by `bazel run`.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Manifest describes a single svcinit session.
+type Manifest struct {
+	Target        string            `json:"target"`
+	Pid           int               `json:"pid"`
+	StartTime     time.Time         `json:"start_time"`
+	SvcctlAddress string            `json:"svcctl_address"`
+	Ports         map[string]string `json:"ports"`
+	Hosts         map[string]string `json:"hosts,omitempty"`
+	SocketDir     string            `json:"socket_dir"`
+	TmpDir        string            `json:"tmpdir"`
+	Ready         bool              `json:"ready"`
+	ProcessGroups []ProcessGroup    `json:"process_groups"`
+}
+
+// ProcessGroup records a service's process group, so that it can be reaped if svcinit crashes.
+type ProcessGroup struct {
+	Pgid int `json:"pgid"`
+	// StartTime guards against killing an unrelated process that reused the pgid.
+	// It is empty on platforms where it cannot be determined, in which case the group is
+	// only reaped once its leader has exited.
+	StartTime string `json:"start_time,omitempty"`
+}
+
+// NewProcessGroups records the given process group ids along with their leaders' start times.
+func NewProcessGroups(pgids []int) []ProcessGroup {
+	groups := make([]ProcessGroup, 0, len(pgids))
+	for _, pgid := range pgids {
+		groups = append(groups, ProcessGroup{Pgid: pgid, StartTime: processStartTime(pgid)})
+	}
+	return groups
+}
+
+func (g ProcessGroup) alive() bool {
+	if processAlive(g.Pgid) {
+		// Without a start time we cannot tell whether the pid was reused, so leave it alone.
+		return g.StartTime != "" && g.StartTime == processStartTime(g.Pgid)
+	}
+	// The leader is gone, but it may have left children behind. The kernel does not
+	// hand out a pid while it is still in use as a pgid, so these must be ours.
+	return processGroupAlive(g.Pgid)
+}
+
+// RegistryDir returns the directory where sessions are recorded. Note that svcinit overrides
+// TMPDIR for its services, so this must be called before that happens.
+func RegistryDir() string {
+	if dir := os.Getenv("RULES_ITEST_SESSION_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "rules_itest", "sessions")
+	}
+	return filepath.Join(os.TempDir(), "rules_itest_sessions")
+}
+
+// NormalizeTarget turns a user-provided label like `//foo:bar` into the canonical form svcinit records.
+func NormalizeTarget(target string) string {
+	if strings.HasPrefix(target, "//") {
+		return "@@" + target
+	}
+	if strings.HasPrefix(target, "@") && !strings.HasPrefix(target, "@@") {
+		return "@" + target
+	}
+	return target
+}
+
+func (m *Manifest) basePath(dir string) string {
+	name := strings.NewReplacer("@", "", "/", "_", ":", "_").Replace(m.Target)
+	return filepath.Join(dir, fmt.Sprintf("%s.%d", name, m.Pid))
+}
+
+// ManifestPath returns the path of the JSON manifest for this session.
+func (m *Manifest) ManifestPath(dir string) string {
+	return m.basePath(dir) + ".json"
+}
+
+// EnvPath returns the path of the sourceable env file for this session.
+func (m *Manifest) EnvPath(dir string) string {
+	return m.basePath(dir) + ".env"
+}
+
+// Write atomically (re)writes the manifest and env files for this session.
+func (m *Manifest) Write(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomically(m.ManifestPath(dir), data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(m.EnvPath(dir), m.env())
+}
+
+func (m *Manifest) env() []byte {
+	assignedPorts, _ := json.Marshal(m.Ports)
+	assignedHosts, _ := json.Marshal(m.Hosts)
+
+	var b strings.Builder
+	for _, kv := range [][2]string{
+		{"ITEST_TARGET", m.Target},
+		{"ITEST_SVCINIT_PID", fmt.Sprint(m.Pid)},
+		{"SVCCTL_ADDRESS", m.SvcctlAddress},
+		{"SVCCTL_PORT", m.SvcctlAddress[strings.LastIndex(m.SvcctlAddress, ":")+1:]},
+		{"ASSIGNED_PORTS", string(assignedPorts)},
+		{"ASSIGNED_HOSTS", string(assignedHosts)},
+		{"SOCKET_DIR", m.SocketDir},
+		{"TEST_TMPDIR", m.TmpDir},
+	} {
+		fmt.Fprintf(&b, "export %s=%s\n", kv[0], shellQuote(kv[1]))
+	}
+	return []byte(b.String())
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the files for this session.
+func (m *Manifest) Remove(dir string) {
+	os.Remove(m.ManifestPath(dir))
+	os.Remove(m.EnvPath(dir))
+}
+
+// List returns every session recorded in dir, including ones whose svcinit is no longer alive.
+func List(dir string) ([]*Manifest, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*Manifest
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Raced with a session exiting.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		m := &Manifest{}
+		err = json.Unmarshal(data, m)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		manifests = append(manifests, m)
+	}
+
+	slices.SortFunc(manifests, func(a, b *Manifest) int {
+		return b.StartTime.Compare(a.StartTime)
+	})
+	return manifests, nil
+}
+
+// Alive returns true if the svcinit process for this session is still running.
+func (m *Manifest) Alive() bool {
+	return processAlive(m.Pid)
+}
+
+// Find returns the most recently started live session for target.
+func Find(dir string, target string) (*Manifest, error) {
+	target = NormalizeTarget(target)
+
+	manifests, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range manifests {
+		if m.Target == target && m.Alive() {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no running session found for %s in %s", target, dir)
+}
+
+// ReapStale removes the sessions for target whose svcinit is no longer running, killing any process
+// groups they left behind. This happens when svcinit is SIGKILLed and cannot stop its services, which
+// then keep holding their ports. It returns the ids of the process groups that were killed.
+func ReapStale(dir string, target string) ([]int, error) {
+	manifests, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var reaped []int
+	for _, m := range manifests {
+		if m.Target != target || m.Pid == os.Getpid() || m.Alive() {
+			continue
+		}
+
+		for _, g := range m.ProcessGroups {
+			if !g.alive() {
+				continue
+			}
+			err := killProcessGroup(g.Pgid)
+			if err != nil {
+				return reaped, fmt.Errorf("killing process group %d from stale session %d: %w", g.Pgid, m.Pid, err)
+			}
+			reaped = append(reaped, g.Pgid)
+		}
+		m.Remove(dir)
+	}
+	return reaped, nil
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package session
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processStartTime returns an opaque identifier for when pid was started, so that we never
+// mistake a process that reused the pid for the one we recorded.
+func processStartTime(pid int) string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return ""
+	}
+
+	// The command name is in parens and may contain spaces, so skip past it first.
+	// See proc(5), starttime is the 22nd field.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 20 {
+		return ""
+	}
+	return fields[19]
+}
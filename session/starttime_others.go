@@ -0,0 +1,8 @@
+//go:build !linux
+
+package session
+
+// processStartTime is not available without procfs, so pid reuse cannot be detected.
+func processStartTime(pid int) string {
+	return ""
+}
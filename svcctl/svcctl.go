@@ -6,7 +6,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os/exec"
@@ -60,10 +59,6 @@ func handleHealthCheck(ctx context.Context, r *runner.Runner, _ chan error, w ht
 	w.WriteHeader(http.StatusOK)
 }
 
-func colorize(s svclib.VersionedServiceSpec) string {
-	return s.Colorize(s.Label)
-}
-
 func handleStart(ctx context.Context, r *runner.Runner, serviceErrCh chan error, w http.ResponseWriter, req *http.Request) {
 	s, status, err := getService(r, req)
 	if err != nil {
@@ -71,43 +66,12 @@ func handleStart(ctx context.Context, r *runner.Runner, serviceErrCh chan error,
 		return
 	}
 
-	if s.Deferred {
-		// make sure all the non-deferred dependencies are started
-		for _, dep := range s.Deps {
-			depService := r.GetInstance(dep)
-			if depService == nil {
-				http.Error(w, fmt.Sprintf("dependency %q not found", dep), http.StatusInternalServerError)
-				return
-			}
-
-			if depService.Deferred {
-				continue
-			}
-
-			depsErr := s.WaitUntilHealthy(ctx)
-			if depsErr != nil {
-				http.Error(w, fmt.Sprintf("Failed to wait for %q until healthy", dep), http.StatusInternalServerError)
-			}
-		}
-	}
-
-	log.Printf("Starting %s\n", colorize(s.VersionedServiceSpec))
-
-	err = s.Start(ctx)
+	err = r.StartInstance(ctx, s, serviceErrCh)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// NOTE: it is important to wait here because we started the service without using `StartAll`,
-	// which waits for processes to prevent them from turning into zombies.
-	go func() {
-		waitErr := s.Wait()
-		if waitErr != nil && !s.Killed() {
-			serviceErrCh <- fmt.Errorf(s.Colorize(s.Label) + " exited with error: " + waitErr.Error())
-		}
-	}()
-
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -187,7 +151,7 @@ func handleWait(ctx context.Context, r *runner.Runner, _ chan error, w http.Resp
 }
 
 type portHandler struct {
-	ports svclib.Ports
+	assignedPorts func() (svclib.Ports, svclib.Hosts)
 }
 
 func (p portHandler) handle(ctx context.Context, r *runner.Runner, _ chan error, w http.ResponseWriter, req *http.Request) {
@@ -198,22 +162,68 @@ func (p portHandler) handle(ctx context.Context, r *runner.Runner, _ chan error,
 		return
 	}
 
-	port, ok := p.ports[service]
+	ports, hosts := p.assignedPorts()
+	format := params.Get("format")
+	if host, ok := hosts[service]; ok && format == "host" {
+		// Services with a per-service loopback address have a host even if they have no ports.
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(host))
+		return
+	}
+
+	port, ok := ports[service]
 	if !ok {
 		http.Error(w, "port is not autoassigned", http.StatusBadRequest)
 		return
 	}
 
+	var response string
+	switch format {
+	case "", "port":
+		response = port
+	case "host":
+		response = hosts.Host(service)
+	case "address":
+		response, _ = hosts.Address(ports, service)
+	default:
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(port))
+	w.Write([]byte(response))
+}
+
+type graphHandler struct {
+	assignedPorts func() (svclib.Ports, svclib.Hosts)
+}
+
+func (g graphHandler) handle(ctx context.Context, r *runner.Runner, _ chan error, w http.ResponseWriter, req *http.Request) {
+	ports, _ := g.assignedPorts()
+	graph := r.Graph(ports)
+
+	params := req.URL.Query()
+	switch params.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		graph.WriteJSON(w)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		graph.WriteDOT(w)
+	default:
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+	}
 }
 
-func Serve(ctx context.Context, listener net.Listener, r *runner.Runner, ports svclib.Ports, servicesErrCh chan error) error {
+// Serve handles svcctl requests on listener. assignedPorts returns the current ports and hosts, which may change
+// when a service is assigned new ports.
+func Serve(ctx context.Context, listener net.Listener, r *runner.Runner, assignedPorts func() (svclib.Ports, svclib.Hosts), servicesErrCh chan error) error {
 	mux := http.NewServeMux()
 	handle(ctx, mux, r, servicesErrCh, "GET /v0/healthcheck", handleHealthCheck)
 	handle(ctx, mux, r, servicesErrCh, "GET /v0/start", handleStart)
 	handle(ctx, mux, r, servicesErrCh, "GET /v0/kill", handleKill)
 	handle(ctx, mux, r, servicesErrCh, "GET /v0/wait", handleWait)
-	handle(ctx, mux, r, servicesErrCh, "GET /v0/port", portHandler{ports}.handle)
+	handle(ctx, mux, r, servicesErrCh, "GET /v0/port", portHandler{assignedPorts}.handle)
+	handle(ctx, mux, r, servicesErrCh, "GET /v0/graph", graphHandler{assignedPorts}.handle)
 	return http.Serve(listener, mux)
 }
@@ -0,0 +1,104 @@
+package svclib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tasks publish key/value outputs by writing a JSON object to the file in ITEST_OUTPUTS_FILE.
+// Dependents refer to them as $${output:<label>:<key>}. Unlike other placeholders, they can only be resolved once
+// the task has run, so svcinit expands them into a reference between NUL bytes, which cannot occur in args or env,
+// and the reference is resolved right before the dependent starts.
+const outputRefDelimiter = "\x00"
+
+type outputRef struct {
+	Label   string  `json:"label"`
+	Key     string  `json:"key"`
+	Default *string `json:"default,omitempty"`
+}
+
+// OutputRef returns a reference to an output of a task, to be resolved with ResolveOutputs.
+// If defaultValue is nil, it is an error for the output to be missing.
+func OutputRef(label string, key string, defaultValue *string) string {
+	data, _ := json.Marshal(outputRef{Label: label, Key: key, Default: defaultValue})
+	return outputRefDelimiter + string(data) + outputRefDelimiter
+}
+
+// HasOutputRefs reports whether s contains any references to outputs.
+func HasOutputRefs(s string) bool {
+	return strings.Contains(s, outputRefDelimiter)
+}
+
+// ResolveOutputs replaces the output references in s with the outputs that the tasks wrote.
+func ResolveOutputs(s string) (string, error) {
+	if !HasOutputRefs(s) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		before, rest, found := strings.Cut(s, outputRefDelimiter)
+		b.WriteString(before)
+		if !found {
+			return b.String(), nil
+		}
+		data, after, found := strings.Cut(rest, outputRefDelimiter)
+		if !found {
+			return "", errors.New("malformed output reference")
+		}
+
+		var ref outputRef
+		err := json.Unmarshal([]byte(data), &ref)
+		if err != nil {
+			return "", fmt.Errorf("malformed output reference: %w", err)
+		}
+		value, err := readOutput(ref.Label, ref.Key)
+		if err != nil && ref.Default != nil {
+			// Defaults may refer to other outputs.
+			value, err = ResolveOutputs(*ref.Default)
+		}
+		if err != nil {
+			return "", fmt.Errorf("$${output:%s:%s}: %w", ref.Label, ref.Key, err)
+		}
+		b.WriteString(value)
+		s = after
+	}
+}
+
+// OutputsFile returns the path that the task with the given label writes its outputs to.
+func OutputsFile(label string) string {
+	name := strings.NewReplacer("@", "", "/", "_", ":", "_").Replace(label) + ".json"
+	return filepath.Join(os.Getenv("TEST_TMPDIR"), "outputs", name)
+}
+
+func readOutput(label string, key string) (string, error) {
+	data, err := os.ReadFile(OutputsFile(label))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s did not write any outputs", label)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var outputs map[string]json.RawMessage
+	err = json.Unmarshal(data, &outputs)
+	if err != nil {
+		return "", fmt.Errorf("outputs of %s are not a JSON object: %w", label, err)
+	}
+	raw, ok := outputs[key]
+	if !ok {
+		return "", fmt.Errorf("%s has no output %q", label, key)
+	}
+
+	// Strings are used as is, anything else as JSON, so that numbers and booleans work as expected.
+	// Unmarshaling null into a string succeeds too, so only actual strings are decoded.
+	var value string
+	if raw[0] == '"' && json.Unmarshal(raw, &value) == nil {
+		return value, nil
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,114 @@
+package svclib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeOutputs(t *testing.T, label string, data string) {
+	t.Helper()
+	path := OutputsFile(label)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOutput(t *testing.T) {
+	t.Setenv("TEST_TMPDIR", t.TempDir())
+	writeOutputs(t, "@@//:seed", `{"string": "abc", "number": 42, "bool": true, "object": {"a": [1, 2]}, "null": null}`)
+	writeOutputs(t, "@@//:array", `["abc"]`)
+	writeOutputs(t, "@@//:garbage", `not json`)
+
+	tests := []struct {
+		label   string
+		key     string
+		want    string
+		wantErr string
+	}{
+		{label: "@@//:seed", key: "string", want: "abc"},
+		{label: "@@//:seed", key: "number", want: "42"},
+		{label: "@@//:seed", key: "bool", want: "true"},
+		{label: "@@//:seed", key: "object", want: `{"a": [1, 2]}`},
+		{label: "@@//:seed", key: "null", want: "null"},
+		{label: "@@//:seed", key: "missing", wantErr: `@@//:seed has no output "missing"`},
+		{label: "@@//:missing", key: "string", wantErr: "@@//:missing did not write any outputs"},
+		{label: "@@//:array", key: "string", wantErr: "outputs of @@//:array are not a JSON object"},
+		{label: "@@//:garbage", key: "string", wantErr: "outputs of @@//:garbage are not a JSON object"},
+	}
+
+	for _, tt := range tests {
+		got, err := readOutput(tt.label, tt.key)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readOutput(%q, %q) = %q, %v, want error containing %q", tt.label, tt.key, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readOutput(%q, %q) failed: %v", tt.label, tt.key, err)
+		} else if got != tt.want {
+			t.Errorf("readOutput(%q, %q) = %q, want %q", tt.label, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestResolveOutputs(t *testing.T) {
+	t.Setenv("TEST_TMPDIR", t.TempDir())
+	writeOutputs(t, "@@//:seed", `{"api_key": "secret", "tenant": 7}`)
+
+	stringPtr := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "no references", input: "--flag=value", want: "--flag=value"},
+		{name: "reference", input: "--key=" + OutputRef("@@//:seed", "api_key", nil), want: "--key=secret"},
+		{name: "non-string output", input: OutputRef("@@//:seed", "tenant", nil), want: "7"},
+		{
+			name:  "several references",
+			input: OutputRef("@@//:seed", "api_key", nil) + "/" + OutputRef("@@//:seed", "tenant", nil) + "/",
+			want:  "secret/7/",
+		},
+		{name: "default for missing key", input: OutputRef("@@//:seed", "missing", stringPtr("fallback")), want: "fallback"},
+		{name: "default for missing task", input: OutputRef("@@//:missing", "key", stringPtr("fallback")), want: "fallback"},
+		{name: "empty default", input: "[" + OutputRef("@@//:missing", "key", stringPtr("")) + "]", want: "[]"},
+		{name: "unused default", input: OutputRef("@@//:seed", "api_key", stringPtr("fallback")), want: "secret"},
+		{
+			name:  "default referring to another output",
+			input: OutputRef("@@//:missing", "key", stringPtr(OutputRef("@@//:seed", "tenant", nil))),
+			want:  "7",
+		},
+		{name: "missing key", input: OutputRef("@@//:seed", "missing", nil), wantErr: `$${output:@@//:seed:missing}: @@//:seed has no output "missing"`},
+		{name: "missing task", input: OutputRef("@@//:missing", "key", nil), wantErr: "@@//:missing did not write any outputs"},
+		{name: "unterminated reference", input: "\x00{}", wantErr: "malformed output reference"},
+		{name: "malformed reference", input: "\x00nope\x00", wantErr: "malformed output reference"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveOutputs(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveOutputs(%q) = %q, %v, want error containing %q", tt.input, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveOutputs(%q) failed: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveOutputs(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package svclib
 
-import "encoding/json"
+import (
+	"cmp"
+	"encoding/json"
+	"net"
+)
 
 type Ports map[string]string
 
@@ -15,3 +19,70 @@ func (p Ports) Marshal() ([]byte, error) {
 func (p *Ports) Unmarshal(data []byte) error {
 	return json.Unmarshal(data, p)
 }
+
+// Hosts maps the same names as Ports to the host that clients should use to reach the port.
+type Hosts map[string]string
+
+// Host returns the host for the given port name.
+func (h Hosts) Host(name string) string {
+	return cmp.Or(h[name], "127.0.0.1")
+}
+
+// Address returns the host:port address for the given port name, bracketing IPv6 hosts.
+func (h Hosts) Address(ports Ports, name string) (string, bool) {
+	port, ok := ports[name]
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(h.Host(name), port), true
+}
+
+// PortOptions configures how a named port is assigned. The zero value is TCP on 127.0.0.1.
+type PortOptions struct {
+	// Protocol is "tcp" or "udp".
+	Protocol string `json:"protocol"`
+	// Family is "v4", "v6" or "dual".
+	Family string `json:"family"`
+	// Host is the address to bind. Defaults to the loopback address of the family, or the wildcard address for "dual".
+	Host string `json:"host"`
+}
+
+// Network returns the network to listen on, as understood by the net package.
+func (o PortOptions) Network() string {
+	protocol := cmp.Or(o.Protocol, "tcp")
+	switch o.Family {
+	case "v6":
+		return protocol + "6"
+	case "dual":
+		return protocol
+	default:
+		return protocol + "4"
+	}
+}
+
+// BindHost returns the host to bind.
+func (o PortOptions) BindHost() string {
+	if o.Host != "" {
+		return o.Host
+	}
+	switch o.Family {
+	case "v6":
+		return "::1"
+	case "dual":
+		return "::"
+	default:
+		return "127.0.0.1"
+	}
+}
+
+// ConnectHost returns the host that clients should connect to. Wildcard binds are reached over loopback.
+func (o PortOptions) ConnectHost() string {
+	switch host := o.BindHost(); host {
+	case "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	default:
+		return host
+	}
+}
@@ -0,0 +1,66 @@
+package svclib
+
+import "testing"
+
+func TestPortOptions(t *testing.T) {
+	tests := []struct {
+		options     PortOptions
+		network     string
+		bindHost    string
+		connectHost string
+	}{
+		{PortOptions{}, "tcp4", "127.0.0.1", "127.0.0.1"},
+		{PortOptions{Protocol: "udp"}, "udp4", "127.0.0.1", "127.0.0.1"},
+		{PortOptions{Family: "v4", Host: "0.0.0.0"}, "tcp4", "0.0.0.0", "127.0.0.1"},
+		{PortOptions{Family: "v4", Host: "127.0.0.5"}, "tcp4", "127.0.0.5", "127.0.0.5"},
+		{PortOptions{Family: "v6"}, "tcp6", "::1", "::1"},
+		{PortOptions{Protocol: "udp", Family: "v6"}, "udp6", "::1", "::1"},
+		{PortOptions{Family: "dual"}, "tcp", "::", "::1"},
+		{PortOptions{Family: "dual", Host: "0.0.0.0"}, "tcp", "0.0.0.0", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.options.Network(); got != tt.network {
+			t.Errorf("%+v.Network() = %q, want %q", tt.options, got, tt.network)
+		}
+		if got := tt.options.BindHost(); got != tt.bindHost {
+			t.Errorf("%+v.BindHost() = %q, want %q", tt.options, got, tt.bindHost)
+		}
+		if got := tt.options.ConnectHost(); got != tt.connectHost {
+			t.Errorf("%+v.ConnectHost() = %q, want %q", tt.options, got, tt.connectHost)
+		}
+	}
+}
+
+func TestHostsAddress(t *testing.T) {
+	ports := Ports{
+		"//:v4":         "1234",
+		"//:v6":         "2345",
+		"//:default":    "3456",
+		"//:svc.health": "4567",
+	}
+	hosts := Hosts{
+		"//:v4":         "127.0.0.2",
+		"//:v6":         "::1",
+		"//:svc.health": "fd00::1",
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		ok      bool
+	}{
+		{"//:v4", "127.0.0.2:1234", true},
+		{"//:v6", "[::1]:2345", true},
+		{"//:default", "127.0.0.1:3456", true},
+		{"//:svc.health", "[fd00::1]:4567", true},
+		{"//:missing", "", false},
+	}
+
+	for _, tt := range tests {
+		address, ok := hosts.Address(ports, tt.name)
+		if address != tt.address || ok != tt.ok {
+			t.Errorf("Address(%q) = %q, %v, want %q, %v", tt.name, address, ok, tt.address, tt.ok)
+		}
+	}
+}
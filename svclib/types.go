@@ -1,41 +1,86 @@
 package svclib
 
-import "rules_itest/logger"
+import (
+	"os"
+
+	"rules_itest/logger"
+)
 
 // Created by Starlark
 type ServiceSpec struct {
 	// Type can be "service", "task", or "group".
-	Type                    string            `json:"type"`
-	Label                   string            `json:"label"`
-	Args                    []string          `json:"args"`
-	Env                     map[string]string `json:"env"`
-	Exe                     string            `json:"exe"`
-	HttpHealthCheckAddress  string            `json:"http_health_check_address"`
-	ExpectedStartDuration   string            `json:"expected_start_duration"`
-	HealthCheck             string            `json:"health_check"`
-	HealthCheckLabel        string            `json:"health_check_label"`
-	HealthCheckArgs         []string          `json:"health_check_args"`
-	HealthCheckInterval     string            `json:"health_check_interval"`
-	HealthCheckTimeout      string            `json:"health_check_timeout"`
-	VersionFile             string            `json:"version_file"`
-	Deps                    []string          `json:"deps"`
-	Port                    string            `json:"port"`
-	AutoassignPort          bool              `json:"autoassign_port"`
-	SoReuseportAware        bool              `json:"so_reuseport_aware"`
-	NamedPorts              map[string]string `json:"named_ports"`
-	HotReloadable           bool              `json:"hot_reloadable"`
-	PortAliases             map[string]string `json:"port_aliases"`
-	ShutdownSignal          string            `json:"shutdown_signal"`
-	ShutdownTimeout         string            `json:"shutdown_timeout"`
-	EnforceForcefulShutdown bool              `json:"enforce_graceful_shutdown"`
-	Deferred                bool              `json:"deferred"`
+	Type                    string                 `json:"type"`
+	Label                   string                 `json:"label"`
+	Args                    []string               `json:"args"`
+	Env                     map[string]string      `json:"env"`
+	Exe                     string                 `json:"exe"`
+	HttpHealthCheckAddress  string                 `json:"http_health_check_address"`
+	ExpectedStartDuration   string                 `json:"expected_start_duration"`
+	HealthCheck             string                 `json:"health_check"`
+	HealthCheckLabel        string                 `json:"health_check_label"`
+	HealthCheckArgs         []string               `json:"health_check_args"`
+	HealthCheckInterval     string                 `json:"health_check_interval"`
+	HealthCheckTimeout      string                 `json:"health_check_timeout"`
+	VersionFile             string                 `json:"version_file"`
+	Deps                    []string               `json:"deps"`
+	DepConditions           map[string]string      `json:"dep_conditions"`
+	Port                    string                 `json:"port"`
+	AutoassignPort          bool                   `json:"autoassign_port"`
+	SoReuseportAware        bool                   `json:"so_reuseport_aware"`
+	NamedPorts              map[string]string      `json:"named_ports"`
+	PortOptions             map[string]PortOptions `json:"port_options"`
+	HotReloadable           bool                   `json:"hot_reloadable"`
+	HotReloadSignal         string                 `json:"hot_reload_signal"`
+	HotReloadHttpAddress    string                 `json:"hot_reload_http_address"`
+	PortAliases             map[string]string      `json:"port_aliases"`
+	ShutdownSignal          string                 `json:"shutdown_signal"`
+	ShutdownTimeout         string                 `json:"shutdown_timeout"`
+	EnforceForcefulShutdown bool                   `json:"enforce_graceful_shutdown"`
+	Deferred                bool                   `json:"deferred"`
+	WorkingDir              string                 `json:"working_dir"`
+	Rlimits                 map[string]string      `json:"rlimits"`
+	Nice                    int                    `json:"nice"`
+	Umask                   string                 `json:"umask"`
+	Pty                     bool                   `json:"pty"`
+	Lazy                    bool                   `json:"lazy"`
+	SocketActivation        bool                   `json:"socket_activation"`
 }
 
+// Conditions that can be placed on an edge in `dep_conditions`.
+// Edges without an explicit condition use DepConditionHealthy.
+const (
+	DepConditionStarted = "service_started"
+	DepConditionHealthy = "service_healthy"
+)
+
 // Our internal representation.
 type VersionedServiceSpec struct {
 	ServiceSpec
 	Version string
 	Color   string
+	// Listening sockets that svcinit bound on behalf of the service, to be passed to it on start.
+	Sockets []Socket
+	// The ports assigned to the service, sorted by name.
+	AssignedPorts []AssignedPort
+}
+
+// AssignedPort is a port that svcinit assigned to a service.
+type AssignedPort struct {
+	// Name is the port name, or empty for the autoassigned port.
+	Name     string
+	Protocol string
+	// Host is the host that clients connect to.
+	Host string
+	// BindHost is the host the service was asked to bind, which is a wildcard address if one was requested.
+	BindHost string
+	Port     string
+}
+
+// Socket is a listening socket passed to a service using the systemd socket activation protocol.
+type Socket struct {
+	// Name is exported in LISTEN_FDNAMES. It is the port name, or "default" for the autoassigned port.
+	Name string
+	File *os.File
 }
 
 func (v VersionedServiceSpec) Colorize(label string) string {
@@ -20,6 +20,7 @@ func main() {
 	fileToOpen := flag.String("file-to-open", "", "A file to open to check runfiles")
 	soReuseport := flag.Bool("so-reuseport", false, "If true, sets SO_REUSEPORT when binding the address")
 	port := flag.String("port", "", "Port to bind")
+	host := flag.String("host", "127.0.0.1", "Address to bind")
 
 	flag.Parse()
 
@@ -71,7 +72,7 @@ func main() {
 		w.Write([]byte(strconv.Itoa(fibSink)))
 	})
 
-	serve(*port, *soReuseport)
+	serve(*host, *port, *soReuseport)
 }
 
 func fib(n int) int {
@@ -12,7 +12,7 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func serve(port string, soReuseport bool) {
+func serve(host string, port string, soReuseport bool) {
 	lc := net.ListenConfig{
 		Control: func(network, address string, conn syscall.RawConn) error {
 			if !soReuseport {
@@ -30,7 +30,7 @@ func serve(port string, soReuseport bool) {
 		},
 	}
 
-	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:"+port)
+	l, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort(host, port))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,11 +2,14 @@
 
 package main
 
-import "net/http"
+import (
+	"net"
+	"net/http"
+)
 
-func serve(port string, soReuseport bool) {
+func serve(host string, port string, soReuseport bool) {
 	if soReuseport {
 		panic("SO_REUSEPORT not supported on Windows!")
 	}
-	http.ListenAndServe("127.0.0.1:"+port, nil)
+	http.ListenAndServe(net.JoinHostPort(host, port), nil)
 }
@@ -0,0 +1,37 @@
+package loopback
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestReplicasShareThePort(t *testing.T) {
+	seen := map[string]string{}
+	for _, label := range []string{"@@//loopback:replica_a", "@@//loopback:replica_b"} {
+		host, err := exec.Command(os.Getenv("GET_ASSIGNED_PORT_BIN"), "host:"+label).Output()
+		if err != nil {
+			t.Fatalf("failed to get host of %s: %v", label, err)
+		}
+
+		ip := net.ParseIP(string(host))
+		if ip == nil || !ip.IsLoopback() || ip.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Fatalf("expected %s to get its own loopback address, got %q", label, host)
+		}
+		if other, ok := seen[string(host)]; ok {
+			t.Fatalf("%s and %s got the same address %s", label, other, host)
+		}
+		seen[string(host)] = label
+
+		resp, err := http.Get("http://" + net.JoinHostPort(string(host), "18080"))
+		if err != nil {
+			t.Fatalf("%s is not reachable on its own address: %v", label, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s returned status %d", label, resp.StatusCode)
+		}
+	}
+}
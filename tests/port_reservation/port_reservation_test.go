@@ -0,0 +1,46 @@
+package port_reservation
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// A second session tries to lock the port's lock file before handing the port out, so if we cannot lock it,
+// neither can any other session while this one is alive.
+func TestPortIsReservedForThisSession(t *testing.T) {
+	port, err := exec.Command(os.Getenv("GET_ASSIGNED_PORT_BIN"), "@@//port_reservation:reserved_service").Output()
+	if err != nil {
+		t.Fatalf("failed to get port: %v", err)
+	}
+
+	path := filepath.Join(os.Getenv("PORT_RESERVATION_DIR"), "tcp-"+string(port)+".lock")
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("port %s was not reserved: %v", port, err)
+	}
+	defer f.Close()
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == nil {
+		t.Fatalf("port %s could be reserved again while its session is alive", port)
+	}
+	if !errors.Is(err, unix.EWOULDBLOCK) {
+		t.Fatalf("unexpected error locking %s: %v", path, err)
+	}
+
+	owner, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The service manager runs the test directly.
+	if want := strconv.Itoa(os.Getppid()); strings.TrimSpace(string(owner)) != want {
+		t.Fatalf("expected port %s to be reserved by the service manager (pid %s), got %q", port, want, owner)
+	}
+}
@@ -0,0 +1,28 @@
+// bind_once fails like a server whose port was taken on its first start, and serves on the port it is given after that.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	port := os.Args[1]
+
+	marker := filepath.Join(os.Getenv("TEST_TMPDIR"), "bind_once_first_port")
+	if _, err := os.Stat(marker); os.IsNotExist(err) {
+		err := os.WriteFile(marker, []byte(port), 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatalf("listen tcp 127.0.0.1:%s: bind: address already in use", port)
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	log.Fatal(http.ListenAndServe("127.0.0.1:"+port, nil))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestServiceRebound(t *testing.T) {
+	firstPort, err := os.ReadFile(filepath.Join(os.Getenv("TEST_TMPDIR"), "bind_once_first_port"))
+	if err != nil {
+		t.Fatalf("the service never failed to bind: %v", err)
+	}
+
+	port, err := exec.Command(os.Getenv("GET_ASSIGNED_PORT_BIN"), "@@//rebind:bind_once_service").Output()
+	if err != nil {
+		t.Fatalf("failed to get port: %v", err)
+	}
+	if string(port) == string(firstPort) {
+		t.Fatalf("expected a new port after the bind failure, still got %s", port)
+	}
+
+	resp, err := http.Get("http://127.0.0.1:" + string(port))
+	if err != nil {
+		t.Fatalf("service is not reachable on its new port %s: %v", port, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, port string, path string) string {
+	t.Helper()
+
+	resp, err := http.Get("http://127.0.0.1:" + port + path)
+	if err != nil {
+		t.Fatalf("request to port %s failed: %v", port, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response from port %s failed: %v", port, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("port %s returned status %d: %s", port, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func getPort(t *testing.T, name string) string {
+	t.Helper()
+
+	port, err := exec.Command(os.Getenv("GET_ASSIGNED_PORT_BIN"), name).Output()
+	if err != nil {
+		t.Fatalf("failed to get port %s: %v", name, err)
+	}
+	return string(port)
+}
+
+func TestLazyServiceStartedByConnection(t *testing.T) {
+	port := getPort(t, "@@//socket_activation:lazy_service")
+
+	// Only the service manager listens on the port until this connection arrives, so the service must start after it.
+	connected := time.Now()
+	started, err := time.Parse(time.RFC3339Nano, get(t, port, "/started"))
+	if err != nil {
+		t.Fatalf("invalid start time: %v", err)
+	}
+	if started.Before(connected) {
+		t.Fatalf("expected the service to be started by the connection at %s, but it started at %s", connected, started)
+	}
+
+	if name := get(t, port, "/"); name != "default" {
+		t.Fatalf("expected the connection to arrive on the default socket, got %q", name)
+	}
+}
+
+func TestSocketActivatedService(t *testing.T) {
+	if name := get(t, getPort(t, "@@//socket_activation:socket_activated_service"), "/"); name != "default" {
+		t.Fatalf("expected the connection to arrive on the default socket, got %q", name)
+	}
+	if name := get(t, getPort(t, "@@//socket_activation:socket_activated_service.admin"), "/"); name != "admin" {
+		t.Fatalf("expected the connection to arrive on the admin socket, got %q", name)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+// socket_server serves HTTP on the sockets it inherits through the systemd socket activation protocol.
+// It never binds a port itself, so it only works if the service manager passed it its sockets.
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	started := time.Now()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		log.Fatalf("LISTEN_PID is %q, but our pid is %d", os.Getenv("LISTEN_PID"), os.Getpid())
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		log.Fatalf("no sockets were passed, LISTEN_FDS is %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	errCh := make(chan error)
+	for i := range count {
+		// Passed sockets start right after stderr.
+		listener, err := net.FileListener(os.NewFile(uintptr(3+i), names[i]))
+		if err != nil {
+			log.Fatalf("fd %d is not a listening socket: %v", 3+i, err)
+		}
+
+		name := names[i]
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(name))
+		})
+		mux.HandleFunc("/started", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(started.Format(time.RFC3339Nano)))
+		})
+		go func() {
+			errCh <- http.Serve(listener, mux)
+		}()
+	}
+	log.Fatal(<-errCh)
+}
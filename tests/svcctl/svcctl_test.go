@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
@@ -53,6 +54,77 @@ func TestSvcctlPortRetrieval(t *testing.T) {
 	}
 }
 
+func TestSvcctlGraph(t *testing.T) {
+	speedyPort := getSpeedyPort(t)
+
+	port := os.Getenv("SVCCTL_PORT")
+	if port == "" {
+		t.Errorf("SVCCTL_PORT not set")
+	}
+	svcctlHost := "http://127.0.0.1:" + port
+
+	resp, err := http.Get(svcctlHost + "/v0/graph")
+	if err != nil {
+		t.Fatalf("Failed to get graph: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var graph struct {
+		Nodes []struct {
+			Label string            `json:"label"`
+			Type  string            `json:"type"`
+			Ports map[string]string `json:"ports"`
+		} `json:"nodes"`
+		Edges []struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"edges"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&graph)
+	if err != nil {
+		t.Fatalf("Failed to decode graph: %v", err)
+	}
+
+	foundSpeedy := false
+	for _, node := range graph.Nodes {
+		if node.Label != "@@//:_speedy_service" {
+			continue
+		}
+		foundSpeedy = true
+		if node.Type != "service" {
+			t.Errorf("Got type %s, want service", node.Type)
+		}
+		if node.Ports["@@//:_speedy_service"] != speedyPort {
+			t.Errorf("Got port %s, want %s", node.Ports["@@//:_speedy_service"], speedyPort)
+		}
+	}
+	if !foundSpeedy {
+		t.Errorf("Graph is missing @@//:_speedy_service")
+	}
+
+	foundEdge := false
+	for _, edge := range graph.Edges {
+		if edge.From == "@@//:speedy_service" && edge.To == "@@//:_speedy_service" {
+			foundEdge = true
+		}
+	}
+	if !foundEdge {
+		t.Errorf("Graph is missing the edge from @@//:speedy_service to @@//:_speedy_service")
+	}
+
+	params := url.Values{}
+	params.Add("format", "dot")
+	resp, err = http.Get(svcctlHost + "/v0/graph?" + params.Encode())
+	if err != nil {
+		t.Fatalf("Failed to get graph: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Got status code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
 func TestSvcctl(t *testing.T) {
 	speedyPort := getSpeedyPort(t)
 	sleepyPort := getSleepyPort(t)
@@ -0,0 +1,49 @@
+"""Runs a test with some of the rules_itest flags set, so that features behind those flags can be
+tested without changing the flags for every other test."""
+
+_PER_SERVICE_LOOPBACK = "@rules_itest//:per_service_loopback"
+_PORT_RESERVATION_DIR = "@rules_itest//:port_reservation_dir"
+
+def _flags_transition_impl(settings, attr):
+    return {
+        _PER_SERVICE_LOOPBACK: attr.per_service_loopback or settings[_PER_SERVICE_LOOPBACK],
+        _PORT_RESERVATION_DIR: attr.port_reservation_dir or settings[_PORT_RESERVATION_DIR],
+    }
+
+_flags_transition = transition(
+    implementation = _flags_transition_impl,
+    inputs = [_PER_SERVICE_LOOPBACK, _PORT_RESERVATION_DIR],
+    outputs = [_PER_SERVICE_LOOPBACK, _PORT_RESERVATION_DIR],
+)
+
+def _with_flags_test_impl(ctx):
+    test = ctx.attr.test[0]
+
+    executable = ctx.actions.declare_file(ctx.label.name)
+    ctx.actions.symlink(
+        output = executable,
+        target_file = test[DefaultInfo].files_to_run.executable,
+        is_executable = True,
+    )
+
+    providers = [DefaultInfo(
+        executable = executable,
+        runfiles = test[DefaultInfo].default_runfiles,
+    )]
+    if RunEnvironmentInfo in test:
+        providers.append(test[RunEnvironmentInfo])
+    return providers
+
+with_flags_test = rule(
+    implementation = _with_flags_test_impl,
+    attrs = {
+        "test": attr.label(
+            cfg = _flags_transition,
+            executable = True,
+            mandatory = True,
+        ),
+        "per_service_loopback": attr.bool(),
+        "port_reservation_dir": attr.string(),
+    },
+    test = True,
+)